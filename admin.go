@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/dxe/alc-mobile-api/model"
+	"github.com/jmoiron/sqlx"
 )
 
 func (s *server) admin() {
@@ -17,11 +20,20 @@ func (s *server) admin() {
 		s.adminError(err)
 		return
 	}
-	s.renderTemplate("index", users)
+	receiptErrors, err := model.ListReceiptErrors(s.db)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	s.renderTemplate("index", map[string]interface{}{
+		"Users":         users,
+		"ReceiptErrors": receiptErrors,
+		"Cache":         s.cache.stats(),
+	})
 }
 
 func (s *server) adminConferences() {
-	conferenceData, err := model.ListConferences(s.db, model.ConferenceOptions{ConvertTimeToUSPacific: true})
+	conferenceData, err := model.ListConferences(s.db)
 	if err != nil {
 		s.adminError(err)
 		return
@@ -33,7 +45,7 @@ func (s *server) adminConferenceDetails() {
 	id := s.r.URL.Query().Get("id")
 	if id == "" {
 		// Form to create a new conference
-		s.renderTemplate("conference_details", model.Conference{})
+		s.renderTemplate("conference_details", model.Conference{Timezone: model.DefaultTimezone, ReminderMinutes: 15})
 		return
 	}
 	// Form to update an existing conference
@@ -57,26 +69,48 @@ func (s *server) adminConferenceSave() {
 		return
 	}
 
-	startTime, err := time.Parse(isoTimeLayout, s.r.Form.Get("StartDate"))
+	timezone := strings.TrimSpace(s.r.Form.Get("Timezone"))
+	loc, err := model.Conference{Timezone: timezone}.TimeZone()
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+
+	startTime, err := time.ParseInLocation(pickerTimeLayout, s.r.Form.Get("StartDate"), loc)
 	if err != nil {
 		s.adminError(errors.New("start time is invalid"))
 		return
 	}
 
-	endTime, err := time.Parse(isoTimeLayout, s.r.Form.Get("EndDate"))
+	endTime, err := time.ParseInLocation(pickerTimeLayout, s.r.Form.Get("EndDate"), loc)
 	if err != nil {
 		s.adminError(errors.New("end time is invalid"))
 		return
 	}
 
+	reminderMinutes, err := strconv.Atoi(s.r.Form.Get("ReminderMinutes"))
+	if err != nil || reminderMinutes < 0 {
+		s.adminError(errors.New("reminder minutes is invalid"))
+		return
+	}
+
+	// Only editors of all conferences may create new ones.
+	if err := s.authorize(model.RoleEditor, id); err != nil {
+		s.adminError(err)
+		return
+	}
+
 	conference := model.Conference{
-		ID:        id,
-		Name:      s.r.Form.Get("Name"),
-		StartDate: startTime.Format(dbTimeLayout),
-		EndDate:   endTime.Format(dbTimeLayout),
+		ID:              id,
+		Name:            s.r.Form.Get("Name"),
+		StartDate:       startTime,
+		EndDate:         endTime,
+		Timezone:        timezone,
+		ReminderMinutes: reminderMinutes,
 	}
 	// update the database
-	if err := model.SaveConference(s.db, conference); err != nil {
+	save := func(db model.DB) (int, error) { return model.SaveConference(db, conference) }
+	if err := s.auditSave(model.AuditEntityConference, conference.ID, getConference, save); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -84,8 +118,18 @@ func (s *server) adminConferenceSave() {
 }
 
 func (s *server) adminConferenceDelete() {
-	id := s.r.URL.Query().Get("id")
-	if err := model.DeleteConference(s.db, id); err != nil {
+	id := s.r.FormValue("id")
+	conferenceID, err := strconv.Atoi(id)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	if err := s.authorize(model.RoleEditor, conferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
+	del := func(db model.DB) error { return model.DeleteConference(db, id) }
+	if err := s.auditChange(model.AuditEntityConference, model.AuditActionDelete, id, getConference, del); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -140,6 +184,12 @@ func (s *server) adminLocationSave() {
 		return
 	}
 
+	// Locations are shared by all conferences.
+	if err := s.authorize(model.RoleEditor, 0); err != nil {
+		s.adminError(err)
+		return
+	}
+
 	location := model.Location{
 		ID:      id,
 		Name:    s.r.Form.Get("Name"),
@@ -150,7 +200,8 @@ func (s *server) adminLocationSave() {
 		Lng:     lng,
 	}
 	// update the database
-	if err := model.SaveLocation(s.db, location); err != nil {
+	save := func(db model.DB) (int, error) { return model.SaveLocation(db, location) }
+	if err := s.auditSave(model.AuditEntityLocation, location.ID, getLocation, save); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -158,8 +209,13 @@ func (s *server) adminLocationSave() {
 }
 
 func (s *server) adminLocationDelete() {
-	id := s.r.URL.Query().Get("id")
-	if err := model.DeleteLocation(s.db, id); err != nil {
+	if err := s.authorize(model.RoleEditor, 0); err != nil {
+		s.adminError(err)
+		return
+	}
+	id := s.r.FormValue("id")
+	del := func(db model.DB) error { return model.DeleteLocation(db, id) }
+	if err := s.auditChange(model.AuditEntityLocation, model.AuditActionDelete, id, getLocation, del); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -177,7 +233,11 @@ func (s *server) adminEvents() {
 			return
 		}
 	}
-	eventData, err := model.ListEvents(s.db, model.EventOptions{ConvertTimeToUSPacific: true, ConferenceId: conferenceId})
+	if err := s.authorize(model.RoleViewer, conferenceId); err != nil {
+		s.adminError(err)
+		return
+	}
+	eventData, err := model.ListEvents(s.db, model.EventOptions{ConferenceId: conferenceId})
 	if err != nil {
 		panic(err)
 	}
@@ -195,7 +255,7 @@ func (s *server) adminEventDetails() {
 	if id == "" {
 		// Form to create a new event
 		s.renderTemplate("event_details", map[string]interface{}{
-			"Event":     model.Event{},
+			"Event":     model.Event{ConferenceID: configInt("DEFAULT_CONFERENCE_ID")},
 			"Locations": locations,
 		})
 		return
@@ -206,6 +266,10 @@ func (s *server) adminEventDetails() {
 		s.adminError(err)
 		return
 	}
+	if err := s.authorize(model.RoleViewer, event.ConferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
 	s.renderTemplate("event_details", map[string]interface{}{
 		"Event":     event,
 		"Locations": locations,
@@ -230,8 +294,30 @@ func (s *server) adminEventSave() {
 		s.adminError(err)
 		return
 	}
+	if err := s.authorize(model.RoleEditor, conferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
+	if id != 0 {
+		// Don't let the event be moved out of a conference the admin
+		// can't edit.
+		existing, err := model.GetEventByID(s.db, strconv.Itoa(id))
+		if err != nil {
+			s.adminError(err)
+			return
+		}
+		if err := s.authorize(model.RoleEditor, existing.ConferenceID); err != nil {
+			s.adminError(err)
+			return
+		}
+	}
 
-	startTime, err := time.Parse(isoTimeLayout, s.r.Form.Get("StartTime"))
+	loc, err := s.conferenceTimeZone(conferenceID)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	startTime, err := time.ParseInLocation(pickerTimeLayout, s.r.Form.Get("StartTime"), loc)
 	if err != nil {
 		s.adminError(errors.New("start time is invalid"))
 		return
@@ -259,6 +345,15 @@ func (s *server) adminEventSave() {
 		return
 	}
 
+	var reminderMinutes sql.NullInt64
+	if v := s.r.Form.Get("ReminderMinutes"); v != "" {
+		minutes, err := strconv.Atoi(v)
+		if err != nil || minutes < 0 {
+			s.adminError(errors.New("reminder minutes is invalid"))
+			return
+		}
+		reminderMinutes = sql.NullInt64{Int64: int64(minutes), Valid: true}
+	}
 	var imageURL sql.NullString
 
 	file, fileHeader, err := s.r.FormFile("Image")
@@ -294,16 +389,18 @@ func (s *server) adminEventSave() {
 		ConferenceID:    conferenceID,
 		Name:            s.r.Form.Get("Name"),
 		Description:     s.r.Form.Get("Description"),
-		StartTime:       startTime.Format(dbTimeLayout),
+		StartTime:       startTime,
 		Length:          length,
 		KeyEvent:        keyEvent,
 		BreakoutSession: breakoutSession,
 		LocationID:      locationID,
 		ImageURL:        imageURL,
+		ReminderMinutes: reminderMinutes,
 	}
 
 	// update the database
-	if err := model.SaveEvent(s.db, event); err != nil {
+	save := func(db model.DB) (int, error) { return model.SaveEvent(db, event) }
+	if err := s.auditSave(model.AuditEntityEvent, event.ID, getEvent, save); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -311,8 +408,18 @@ func (s *server) adminEventSave() {
 }
 
 func (s *server) adminEventDelete() {
-	id := s.r.URL.Query().Get("id")
-	if err := model.DeleteEvent(s.db, id); err != nil {
+	id := s.r.FormValue("id")
+	event, err := model.GetEventByID(s.db, id)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	if err := s.authorize(model.RoleEditor, event.ConferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
+	del := func(db model.DB) error { return model.DeleteEvent(db, id) }
+	if err := s.auditChange(model.AuditEntityEvent, model.AuditActionDelete, id, getEvent, del); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -402,6 +509,12 @@ func (s *server) adminInfoSave() {
 		imageURL.Valid = true
 	}
 
+	// Info is shared by all conferences.
+	if err := s.authorize(model.RoleEditor, 0); err != nil {
+		s.adminError(err)
+		return
+	}
+
 	info := model.Info{
 		ID:           id,
 		Title:        s.r.Form.Get("Title"),
@@ -414,7 +527,8 @@ func (s *server) adminInfoSave() {
 	}
 
 	// update the database
-	if err := model.SaveInfo(s.db, info); err != nil {
+	save := func(db model.DB) (int, error) { return model.SaveInfo(db, info) }
+	if err := s.auditSave(model.AuditEntityInfo, info.ID, getInfo, save); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -422,8 +536,13 @@ func (s *server) adminInfoSave() {
 }
 
 func (s *server) adminInfoDelete() {
-	id := s.r.URL.Query().Get("id")
-	if err := model.DeleteInfo(s.db, id); err != nil {
+	if err := s.authorize(model.RoleEditor, 0); err != nil {
+		s.adminError(err)
+		return
+	}
+	id := s.r.FormValue("id")
+	del := func(db model.DB) error { return model.DeleteInfo(db, id) }
+	if err := s.auditChange(model.AuditEntityInfo, model.AuditActionDelete, id, getInfo, del); err != nil {
 		s.adminError(err)
 		return
 	}
@@ -432,8 +551,8 @@ func (s *server) adminInfoDelete() {
 
 func (s *server) adminAnnouncements() {
 	announcementData, err := model.ListAnnouncements(s.db, model.AnnouncementOptions{
-		IncludeScheduled:       true,
-		ConvertTimeToUSPacific: true,
+		IncludeScheduled: true,
+		Admin:            s.email,
 	})
 	if err != nil {
 		panic(err)
@@ -441,20 +560,255 @@ func (s *server) adminAnnouncements() {
 	s.renderTemplate("announcements", announcementData)
 }
 
-func (s *server) adminAnnouncementDetails() {
-	id := s.r.URL.Query().Get("id")
-	if id == "" {
-		// Form to create a new announcement
-		s.renderTemplate("announcement_details", model.Announcement{})
+func (s *server) adminAnnouncementReview() {
+	announcements, err := model.ListAnnouncements(s.db, model.AnnouncementOptions{
+		IncludeScheduled: true,
+		DraftsOnly:       true,
+		Admin:            s.email,
+	})
+	if err != nil {
+		s.adminError(err)
 		return
 	}
-	// Form to update an existing announcement
-	announcement, err := model.GetAnnouncementByID(s.db, id)
+	revisions, err := model.ListDraftRevisions(s.db, s.email)
 	if err != nil {
 		s.adminError(err)
 		return
 	}
-	s.renderTemplate("announcement_details", announcement)
+	s.renderTemplate("announcement_review", map[string]interface{}{
+		"Announcements": announcements,
+		"Revisions":     revisions,
+	})
+}
+
+func (s *server) adminAnnouncementApprove() {
+	id := s.r.FormValue("id")
+	if err := s.authorizeAnnouncement(id); err != nil {
+		s.adminError(err)
+		return
+	}
+	approve := func(db model.DB) error { return model.ApproveAnnouncement(db, id, s.email) }
+	if err := s.auditChange(model.AuditEntityAnnouncement, "approve", id, getAnnouncement, approve); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/announcements/review")
+}
+
+// adminAnnouncementRevisionApprove approves the notice of an edited or
+// retracted announcement. See model.ApproveAnnouncementRevision.
+func (s *server) adminAnnouncementRevisionApprove() {
+	id := s.r.FormValue("id")
+	revision, err := model.GetAnnouncementRevisionByID(s.db, id)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	announcementID := strconv.Itoa(revision.AnnouncementID)
+	if err := s.authorizeAnnouncement(announcementID); err != nil {
+		s.adminError(err)
+		return
+	}
+	// The approval is recorded against the announcement, with the
+	// changes to its revision.
+	getRevision := func(db model.DB, _ string) (interface{}, error) {
+		return model.GetAnnouncementRevisionByID(db, id)
+	}
+	approve := func(db model.DB) error { return model.ApproveAnnouncementRevision(db, id, s.email) }
+	if err := s.auditChange(model.AuditEntityAnnouncement, "approve notice", announcementID, getRevision, approve); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/announcements/review")
+}
+
+func (s *server) adminAnnouncementDetails() {
+	announcement := model.Announcement{
+		ConferenceID: configInt("DEFAULT_CONFERENCE_ID"),
+		Sound:        "default",
+		Priority:     "default",
+	}
+
+	// Form to update an existing announcement
+	if id := s.r.URL.Query().Get("id"); id != "" {
+		var err error
+		announcement, err = model.GetAnnouncementByID(s.db, id)
+		if err != nil {
+			s.adminError(err)
+			return
+		}
+		if err := s.authorize(model.RoleViewer, announcement.ConferenceID); err != nil {
+			s.adminError(err)
+			return
+		}
+	}
+
+	events, err := model.ListEvents(s.db, model.EventOptions{ConferenceId: announcement.ConferenceID})
+	if err != nil {
+		s.adminError(fmt.Errorf("failed to load events: %w", err))
+		return
+	}
+
+	info, err := model.ListInfo(s.db)
+	if err != nil {
+		s.adminError(fmt.Errorf("failed to load info: %w", err))
+		return
+	}
+
+	var revisions []model.AnnouncementRevision
+	if announcement.Sent {
+		revisions, err = model.ListAnnouncementRevisions(s.db, strconv.Itoa(announcement.ID))
+		if err != nil {
+			s.adminError(err)
+			return
+		}
+	}
+
+	s.renderTemplate("announcement_details", map[string]interface{}{
+		"Announcement": announcement,
+		"Events":       events,
+		"Info":         info,
+		"Revisions":    revisions,
+	})
+}
+
+// parseAnnouncementAudience fills in the announcement's targeting
+// fields from the submitted form.
+func (s *server) parseAnnouncementAudience(announcement *model.Announcement) error {
+	if eventID := s.r.Form.Get("TargetEventID"); eventID != "" {
+		id, err := strconv.ParseInt(eventID, 10, 64)
+		if err != nil {
+			return errors.New("target event is invalid")
+		}
+		announcement.TargetEventID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	announcement.TargetPlatform = s.r.Form.Get("TargetPlatform")
+
+	if registeredAfter := s.r.Form.Get("TargetRegisteredAfter"); registeredAfter != "" {
+		loc, err := s.conferenceTimeZone(announcement.ConferenceID)
+		if err != nil {
+			return err
+		}
+		t, err := time.ParseInLocation(pickerTimeLayout, registeredAfter, loc)
+		if err != nil {
+			return errors.New("target registration date is invalid")
+		}
+		announcement.TargetRegisteredAfter = sql.NullTime{Time: t, Valid: true}
+	}
+
+	announcement.TargetUserIDs = nil
+	for _, field := range strings.FieldsFunc(s.r.Form.Get("TargetUserIDs"), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("target user id %q is invalid", field)
+		}
+		announcement.TargetUserIDs = append(announcement.TargetUserIDs, id)
+	}
+
+	return nil
+}
+
+// parseAnnouncementListing fills in how the announcement is listed in
+// the app from the submitted form.
+func (s *server) parseAnnouncementListing(announcement *model.Announcement) error {
+	announcement.ExpiresAt = sql.NullTime{}
+	if expiresAt := s.r.Form.Get("ExpiresAt"); expiresAt != "" {
+		loc, err := s.conferenceTimeZone(announcement.ConferenceID)
+		if err != nil {
+			return err
+		}
+		t, err := time.ParseInLocation(pickerTimeLayout, expiresAt, loc)
+		if err != nil {
+			return errors.New("expiration time is invalid")
+		}
+		announcement.ExpiresAt = sql.NullTime{Time: t, Valid: true}
+	}
+	announcement.Pinned = s.r.Form.Get("Pinned") != ""
+	return nil
+}
+
+// parseAnnouncementPush fills in the announcement's deep link and push
+// delivery options from the submitted form.
+func (s *server) parseAnnouncementPush(announcement *model.Announcement) error {
+	if eventID := s.r.Form.Get("LinkEventID"); eventID != "" {
+		id, err := strconv.ParseInt(eventID, 10, 64)
+		if err != nil {
+			return errors.New("linked event is invalid")
+		}
+		announcement.LinkEventID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	if infoID := s.r.Form.Get("LinkInfoID"); infoID != "" {
+		id, err := strconv.ParseInt(infoID, 10, 64)
+		if err != nil {
+			return errors.New("linked info page is invalid")
+		}
+		announcement.LinkInfoID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	if badge := s.r.Form.Get("Badge"); badge != "" {
+		n, err := strconv.ParseInt(badge, 10, 64)
+		if err != nil || n < 0 {
+			return errors.New("badge count is invalid")
+		}
+		announcement.Badge = sql.NullInt64{Int64: n, Valid: true}
+	}
+
+	if s.r.Form.Get("PlaySound") != "" {
+		announcement.Sound = "default"
+	}
+
+	announcement.ChannelID = s.r.Form.Get("ChannelID")
+	announcement.Urgent = s.r.Form.Get("Urgent") != ""
+
+	announcement.Priority = s.r.Form.Get("Priority")
+	switch announcement.Priority {
+	case "default", "normal", "high":
+	default:
+		return errors.New("priority is invalid")
+	}
+
+	if ttl := s.r.Form.Get("TTLSeconds"); ttl != "" {
+		n, err := strconv.Atoi(ttl)
+		if err != nil || n < 0 {
+			return errors.New("time to live is invalid")
+		}
+		announcement.TTLSeconds = n
+	}
+
+	return nil
+}
+
+// adminAnnouncementRecipients returns the number of users who would
+// receive an announcement with the audience given in the form. It is
+// used to show a live count while editing an announcement.
+func (s *server) adminAnnouncementRecipients() {
+	if err := s.r.ParseForm(); err != nil {
+		s.serveJSON(nil, err)
+		return
+	}
+
+	conferenceID, err := strconv.Atoi(s.r.Form.Get("ConferenceID"))
+	if err != nil {
+		s.serveJSON(nil, errors.New("conference is invalid"))
+		return
+	}
+	if err := s.authorize(model.RoleViewer, conferenceID); err != nil {
+		s.serveJSON(nil, err)
+		return
+	}
+
+	announcement := model.Announcement{ConferenceID: conferenceID, Icon: s.r.Form.Get("Icon")}
+	if err := s.parseAnnouncementAudience(&announcement); err != nil {
+		s.serveJSON(nil, err)
+		return
+	}
+
+	count, err := model.CountAnnouncementRecipients(s.db, announcement)
+	s.serveJSON(count, err)
 }
 
 func (s *server) adminAnnouncementSave() {
@@ -469,13 +823,38 @@ func (s *server) adminAnnouncementSave() {
 		return
 	}
 
+	if id != 0 {
+		existing, err := model.GetAnnouncementByID(s.db, strconv.Itoa(id))
+		if err != nil {
+			s.adminError(err)
+			return
+		}
+		if err := s.authorize(model.RoleAnnouncer, existing.ConferenceID); err != nil {
+			s.adminError(err)
+			return
+		}
+		if existing.Sent {
+			s.adminAnnouncementRevise(existing)
+			return
+		}
+	}
+
 	conferenceID, err := strconv.Atoi(s.r.Form.Get("ConferenceID"))
 	if err != nil {
 		s.adminError(err)
 		return
 	}
+	if err := s.authorize(model.RoleAnnouncer, conferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
 
-	sendTime, err := time.Parse(isoTimeLayout, s.r.Form.Get("SendTime"))
+	loc, err := s.conferenceTimeZone(conferenceID)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	sendTime, err := time.ParseInLocation(pickerTimeLayout, s.r.Form.Get("SendTime"), loc)
 	if err != nil {
 		s.adminError(errors.New("send time is invalid"))
 		return
@@ -491,26 +870,425 @@ func (s *server) adminAnnouncementSave() {
 		URL:          s.r.Form.Get("URL"),
 		URLText:      s.r.Form.Get("URLText"),
 		CreatedBy:    s.email,
-		SendTime:     sendTime.Format(dbTimeLayout),
+		UpdatedBy:    s.email,
+		SendTime:     sendTime,
+	}
+	if err := s.parseAnnouncementAudience(&announcement); err != nil {
+		s.adminError(err)
+		return
+	}
+	if err := s.parseAnnouncementPush(&announcement); err != nil {
+		s.adminError(err)
+		return
+	}
+	if err := s.parseAnnouncementListing(&announcement); err != nil {
+		s.adminError(err)
+		return
 	}
 
 	// update the database
-	if err := model.SaveAnnouncement(s.db, announcement); err != nil {
+	save := func(db model.DB) (int, error) { return model.SaveAnnouncement(db, announcement) }
+	if err := s.auditSave(model.AuditEntityAnnouncement, announcement.ID, getAnnouncement, save); err != nil {
 		s.adminError(err)
 		return
 	}
 	s.redirect("/admin/announcements")
 }
 
-func (s *server) adminAnnouncementDelete() {
+// adminAnnouncementRevise saves changes to an announcement that has
+// already been sent. See model.ReviseAnnouncement.
+func (s *server) adminAnnouncementRevise(announcement model.Announcement) {
+	announcement.Title = s.r.Form.Get("Title")
+	announcement.Message = s.r.Form.Get("Message")
+	announcement.LongMessage = s.r.Form.Get("LongMessage")
+	announcement.Icon = s.r.Form.Get("Icon")
+	announcement.URL = s.r.Form.Get("URL")
+	announcement.URLText = s.r.Form.Get("URLText")
+	if err := s.parseAnnouncementListing(&announcement); err != nil {
+		s.adminError(err)
+		return
+	}
+
+	revise := func(db model.DB) error {
+		return model.ReviseAnnouncement(db, announcement, s.email, s.r.Form.Get("Notice"))
+	}
+	if err := s.auditChange(model.AuditEntityAnnouncement, "revise", strconv.Itoa(announcement.ID), getAnnouncement, revise); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/announcements")
+}
+
+func (s *server) adminAnnouncementDelivery() {
 	id := s.r.URL.Query().Get("id")
-	if err := model.DeleteAnnouncement(s.db, id); err != nil {
+	announcement, err := model.GetAnnouncementByID(s.db, id)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	if err := s.authorize(model.RoleViewer, announcement.ConferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
+	report, err := model.GetDeliveryReport(s.db, id)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	s.renderTemplate("announcement_delivery", map[string]interface{}{
+		"Announcement": announcement,
+		"Report":       report,
+	})
+}
+
+// adminAnnouncementProgress returns each announcement's notification
+// counts, for live updates on the announcements page.
+func (s *server) adminAnnouncementProgress() {
+	progress, err := model.ListNotificationProgress(s.db, s.email)
+	s.serveJSON(progress, err)
+}
+
+func (s *server) adminAnnouncementRetract() {
+	if err := s.r.ParseForm(); err != nil {
+		s.adminError(err)
+		return
+	}
+
+	id, err := strconv.Atoi(s.r.Form.Get("ID"))
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+
+	if err := s.authorizeAnnouncement(strconv.Itoa(id)); err != nil {
+		s.adminError(err)
+		return
+	}
+	retract := func(db model.DB) error { return model.RetractAnnouncement(db, id, s.email, s.r.Form.Get("Notice")) }
+	if err := s.auditChange(model.AuditEntityAnnouncement, "retract", strconv.Itoa(id), getAnnouncement, retract); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/announcements")
+}
+
+func (s *server) adminAnnouncementDelete() {
+	id := s.r.FormValue("id")
+	if err := s.authorizeAnnouncement(id); err != nil {
+		s.adminError(err)
+		return
+	}
+	del := func(db model.DB) error { return model.DeleteAnnouncement(db, id) }
+	if err := s.auditChange(model.AuditEntityAnnouncement, model.AuditActionDelete, id, getAnnouncement, del); err != nil {
 		s.adminError(err)
 		return
 	}
 	s.redirect("/admin/announcements")
 }
 
+func (s *server) adminAnnouncementRequeue() {
+	id := s.r.FormValue("id")
+	if err := s.authorizeAnnouncement(id); err != nil {
+		s.adminError(err)
+		return
+	}
+	requeue := func(db model.DB) error { return model.RequeueFailedNotifications(db, id) }
+	if err := s.auditChange(model.AuditEntityAnnouncement, "requeue", id, getAnnouncement, requeue); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/announcements")
+}
+
+// authorizeAnnouncement checks that the logged in admin may act on
+// the announcement with the given ID.
+func (s *server) authorizeAnnouncement(id string) error {
+	announcement, err := model.GetAnnouncementByID(s.db, id)
+	if err != nil {
+		return err
+	}
+	return s.authorize(model.RoleAnnouncer, announcement.ConferenceID)
+}
+
+func (s *server) adminAdmins() {
+	admins, err := model.ListAdmins(s.db, s.email)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	s.renderTemplate("admins", map[string]interface{}{
+		"Admins": admins,
+		"Roles":  []string{model.RoleViewer, model.RoleAnnouncer, model.RoleEditor, model.RoleOwner},
+	})
+}
+
+// adminAdminSave invites someone to the admin site, or changes their
+// role if they already have one for the conference.
+func (s *server) adminAdminSave() {
+	if err := s.r.ParseForm(); err != nil {
+		s.adminError(err)
+		return
+	}
+
+	admin := model.Admin{
+		Email:     s.r.Form.Get("Email"),
+		Role:      s.r.Form.Get("Role"),
+		InvitedBy: s.email,
+	}
+	var conferenceID int
+	if v := s.r.Form.Get("ConferenceID"); v != "" {
+		var err error
+		conferenceID, err = strconv.Atoi(v)
+		if err != nil {
+			s.adminError(errors.New("conference is invalid"))
+			return
+		}
+		admin.ConferenceID = sql.NullInt64{Int64: int64(conferenceID), Valid: true}
+	}
+	if err := s.authorize(model.RoleOwner, conferenceID); err != nil {
+		s.adminError(err)
+		return
+	}
+
+	// Changing an existing grant's role is audited as an update of it.
+	id, err := model.GetAdminGrantID(s.db, admin.Email, admin.ConferenceID)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	save := func(db model.DB) (int, error) { return model.SaveAdmin(db, admin) }
+	if err := s.auditSave(model.AuditEntityAdmin, id, getAdmin, save); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/admins")
+}
+
+func (s *server) adminAdminDelete() {
+	id := s.r.FormValue("id")
+	admin, err := model.GetAdminByID(s.db, id)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	if err := s.authorize(model.RoleOwner, int(admin.ConferenceID.Int64)); err != nil {
+		s.adminError(err)
+		return
+	}
+	del := func(db model.DB) error { return model.DeleteAdmin(db, id) }
+	if err := s.auditChange(model.AuditEntityAdmin, model.AuditActionDelete, id, getAdmin, del); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/admins")
+}
+
+func (s *server) adminAudit() {
+	q := s.r.URL.Query()
+	options := model.AuditOptions{
+		Actor:      q.Get("actor"),
+		EntityType: q.Get("entity_type"),
+		Search:     q.Get("q"),
+		Admin:      s.email,
+	}
+	if v := q.Get("entity_id"); v != "" {
+		var err error
+		options.EntityID, err = strconv.Atoi(v)
+		if err != nil {
+			s.adminError(errors.New("entity id is invalid"))
+			return
+		}
+	}
+	entries, err := model.ListAuditEntries(s.db, options)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	s.renderTemplate("audit", map[string]interface{}{
+		"Entries":     entries,
+		"Options":     options,
+		"EntityTypes": model.AuditEntityTypes,
+	})
+}
+
+func (s *server) adminTrash() {
+	items, err := model.ListTrash(s.db, s.email)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	s.renderTemplate("trash", items)
+}
+
+func (s *server) adminTrashRestore() {
+	entityType, id := s.r.FormValue("entity_type"), s.r.FormValue("id")
+	get, err := s.authorizeTrash(entityType, id, false)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	restore := func(db model.DB) error { return model.RestoreFromTrash(db, entityType, id) }
+	if err := s.auditChange(entityType, model.AuditActionRestore, id, get, restore); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/trash")
+}
+
+func (s *server) adminTrashPurge() {
+	entityType, id := s.r.FormValue("entity_type"), s.r.FormValue("id")
+	get, err := s.authorizeTrash(entityType, id, true)
+	if err != nil {
+		s.adminError(err)
+		return
+	}
+	purge := func(db model.DB) error { return model.PurgeFromTrash(db, entityType, id) }
+	if err := s.auditChange(entityType, model.AuditActionPurge, id, get, purge); err != nil {
+		s.adminError(err)
+		return
+	}
+	s.redirect("/admin/trash")
+}
+
+// authorizeTrash checks that the logged in admin may restore the
+// entity from the trash, which takes the role that deleting it did,
+// or purge it, which takes an owner. It returns the entity's getter,
+// for auditChange.
+func (s *server) authorizeTrash(entityType, id string, purge bool) (func(db model.DB, id string) (interface{}, error), error) {
+	role := model.RoleEditor
+	if entityType == model.AuditEntityAnnouncement {
+		role = model.RoleAnnouncer
+	}
+	if purge {
+		role = model.RoleOwner
+	}
+
+	switch entityType {
+	case model.AuditEntityConference:
+		conference, err := model.GetConferenceByID(s.db, id)
+		if err != nil {
+			return nil, err
+		}
+		return getConference, s.authorize(role, conference.ID)
+	case model.AuditEntityLocation:
+		return getLocation, s.authorize(role, 0)
+	case model.AuditEntityEvent:
+		event, err := model.GetEventByID(s.db, id)
+		if err != nil {
+			return nil, err
+		}
+		return getEvent, s.authorize(role, event.ConferenceID)
+	case model.AuditEntityInfo:
+		return getInfo, s.authorize(role, 0)
+	case model.AuditEntityAnnouncement:
+		announcement, err := model.GetAnnouncementByID(s.db, id)
+		if err != nil {
+			return nil, err
+		}
+		return getAnnouncement, s.authorize(role, announcement.ConferenceID)
+	}
+	return nil, fmt.Errorf("invalid entity type %q", entityType)
+}
+
+// auditChange calls change to act on the entity with the given ID,
+// and records in the audit log that the logged in admin did so. get
+// loads the entity's state before and after the change, so the audit
+// log can show what changed. The change and its audit entry are made
+// in one transaction, which get and change are passed.
+func (s *server) auditChange(entityType, action, id string, get func(db model.DB, id string) (interface{}, error), change func(db model.DB) error) error {
+	entityID, err := strconv.Atoi(id)
+	if err != nil {
+		return fmt.Errorf("%v id is invalid", entityType)
+	}
+	return model.Transact(s.db, func(tx *sqlx.Tx) error {
+		before, err := get(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(tx); err != nil {
+			return err
+		}
+		// Entities that were deleted outright can't be loaded afterwards.
+		gone := action == model.AuditActionPurge || (action == model.AuditActionDelete && !model.Trashable(entityType))
+		var after interface{}
+		if !gone {
+			if after, err = get(tx, id); err != nil {
+				return err
+			}
+		}
+		return s.audit(tx, entityType, entityID, action, before, after)
+	})
+}
+
+// auditSave is like auditChange, but for saving an entity, which is
+// created if id is 0. save returns the saved entity's ID.
+func (s *server) auditSave(entityType string, id int, get func(db model.DB, id string) (interface{}, error), save func(db model.DB) (int, error)) error {
+	return model.Transact(s.db, func(tx *sqlx.Tx) error {
+		action := model.AuditActionUpdate
+		var before interface{}
+		if id == 0 {
+			action = model.AuditActionCreate
+		} else {
+			var err error
+			if before, err = get(tx, strconv.Itoa(id)); err != nil {
+				return err
+			}
+		}
+		id, err := save(tx)
+		if err != nil {
+			return err
+		}
+		after, err := get(tx, strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		return s.audit(tx, entityType, id, action, before, after)
+	})
+}
+
+func (s *server) audit(db model.DB, entityType string, entityID int, action string, before, after interface{}) error {
+	changes, err := model.AuditChanges(before, after)
+	if err != nil {
+		return err
+	}
+	return model.InsertAuditEntry(db, model.AuditEntry{
+		Actor:      s.email,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Changes:    changes,
+	})
+}
+
+// Getters for auditChange and auditSave.
+
+func getConference(db model.DB, id string) (interface{}, error) {
+	return model.GetConferenceByID(db, id)
+}
+
+func getLocation(db model.DB, id string) (interface{}, error) {
+	return model.GetLocationByID(db, id)
+}
+
+func getEvent(db model.DB, id string) (interface{}, error) {
+	return model.GetEventByID(db, id)
+}
+
+func getInfo(db model.DB, id string) (interface{}, error) {
+	return model.GetInfoByID(db, id)
+}
+
+func getAnnouncement(db model.DB, id string) (interface{}, error) {
+	return model.GetAnnouncementByID(db, id)
+}
+
+func getAdmin(db model.DB, id string) (interface{}, error) {
+	return model.GetAdminByID(db, id)
+}
+
 func (s *server) adminError(err error) {
+	if errors.Is(err, errForbidden) {
+		s.w.WriteHeader(http.StatusForbidden)
+	}
 	s.renderTemplate("error", err.Error())
 }
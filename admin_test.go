@@ -0,0 +1,394 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dxe/alc-mobile-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAtLeast(t *testing.T) {
+	assert.True(t, model.RoleAtLeast(model.RoleOwner, model.RoleViewer))
+	assert.True(t, model.RoleAtLeast(model.RoleAnnouncer, model.RoleAnnouncer))
+	assert.False(t, model.RoleAtLeast(model.RoleAnnouncer, model.RoleEditor))
+	assert.False(t, model.RoleAtLeast("", model.RoleViewer))
+	assert.False(t, model.RoleAtLeast("superuser", model.RoleViewer))
+}
+
+func TestAdminRoles(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'ALC 2021', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (2, 'ALC 2022', '2022-09-24 00:00:00', '2022-09-30 00:00:00')`)
+
+	if err := model.EnsureOwner(db, "Owner@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	conference1 := sql.NullInt64{Int64: 1, Valid: true}
+	if _, err := model.SaveAdmin(db, model.Admin{Email: "announcer@example.com", ConferenceID: conference1, Role: model.RoleAnnouncer}); err != nil {
+		t.Fatal(err)
+	}
+
+	authorize := func(email, role string, conferenceID int) error {
+		s := &server{db: db, email: email}
+		return s.authorize(role, conferenceID)
+	}
+	assert.NoError(t, authorize("owner@example.com", model.RoleOwner, 2))
+	assert.NoError(t, authorize("announcer@example.com", model.RoleAnnouncer, 1))
+	assert.ErrorIs(t, authorize("announcer@example.com", model.RoleAnnouncer, 2), errForbidden)
+	assert.ErrorIs(t, authorize("announcer@example.com", model.RoleEditor, 1), errForbidden)
+	assert.ErrorIs(t, authorize("announcer@example.com", model.RoleViewer, 0), errForbidden,
+		"conference roles don't extend to shared data")
+	assert.ErrorIs(t, authorize("stranger@example.com", model.RoleViewer, 1), errForbidden)
+
+	role, err := model.GetHighestAdminRole(db, "announcer@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, model.RoleAnnouncer, role)
+
+	// Inviting somebody again changes their role.
+	if _, err := model.SaveAdmin(db, model.Admin{Email: "announcer@example.com", ConferenceID: conference1, Role: model.RoleEditor}); err != nil {
+		t.Fatal(err)
+	}
+	admins, err := model.ListAdmins(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, admins, 2) {
+		assert.Equal(t, model.RoleEditor, admins[0].Role)
+		assert.Equal(t, "ALC 2021", admins[0].ConferenceName.String)
+		assert.Equal(t, "owner@example.com", admins[1].Email)
+	}
+
+	// The last owner can't be removed or demoted.
+	assert.Error(t, model.DeleteAdmin(db, "1"))
+	_, err = model.SaveAdmin(db, model.Admin{Email: "owner@example.com", Role: model.RoleViewer})
+	assert.Error(t, err)
+	_, err = model.SaveAdmin(db, model.Admin{Email: "nobody", Role: model.RoleViewer})
+	assert.Error(t, err)
+	_, err = model.SaveAdmin(db, model.Admin{Email: "new@example.com", Role: "superuser"})
+	assert.Error(t, err)
+}
+
+func TestAdminConferenceLists(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'ALC 2021', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (2, 'ALC 2022', '2022-09-24 00:00:00', '2022-09-30 00:00:00')`)
+	for id := 1; id <= 4; id++ {
+		db.MustExec(`
+INSERT INTO announcements (id, conference_id, title, message, long_message, icon, url, url_text, created_by, send_time, sent)
+VALUES (?, ?, ?, '', '', 'bullhorn', '', '', 'test@example.com', UTC_TIMESTAMP - INTERVAL ? MINUTE, 0)
+`, id, (id+1)/2, "Announcement", id)
+	}
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Hall', '', '')`)
+	for _, id := range []string{"2", "3"} {
+		assert.NoError(t, model.DeleteAnnouncement(db, id))
+	}
+	assert.NoError(t, model.DeleteLocation(db, "1"))
+	for _, e := range []model.AuditEntry{
+		{EntityType: model.AuditEntityConference, EntityID: 2, Action: model.AuditActionCreate},
+		{EntityType: model.AuditEntityAnnouncement, EntityID: 1, Action: model.AuditActionCreate},
+		{EntityType: model.AuditEntityAnnouncement, EntityID: 3, Action: model.AuditActionCreate},
+		{EntityType: model.AuditEntityLocation, EntityID: 1, Action: model.AuditActionDelete},
+		{EntityType: model.AuditEntityAdmin, EntityID: 1, Action: model.AuditActionCreate},
+	} {
+		e.Actor, e.Changes = "owner@example.com", `{}`
+		assert.NoError(t, model.InsertAuditEntry(db, e))
+	}
+
+	if err := model.EnsureOwner(db, "owner@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.SaveAdmin(db, model.Admin{Email: "viewer@example.com", ConferenceID: sql.NullInt64{Int64: 1, Valid: true}, Role: model.RoleViewer}); err != nil {
+		t.Fatal(err)
+	}
+
+	announcements := func(admin string) []int {
+		list, err := model.ListAnnouncements(db, model.AnnouncementOptions{IncludeScheduled: true, Admin: admin})
+		assert.NoError(t, err)
+		var ids []int
+		for _, a := range list {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{1, 4}, announcements("owner@example.com"))
+	assert.Equal(t, []int{1}, announcements("Viewer@example.com"))
+
+	trash := func(admin string) []string {
+		items, err := model.ListTrash(db, admin)
+		assert.NoError(t, err)
+		var names []string
+		for _, item := range items {
+			names = append(names, fmt.Sprintf("%v %v", item.EntityType, item.ID))
+		}
+		return names
+	}
+	assert.ElementsMatch(t, []string{"announcement 2", "announcement 3", "location 1"}, trash("owner@example.com"))
+	assert.ElementsMatch(t, []string{"announcement 2", "location 1"}, trash("viewer@example.com"))
+
+	audit := func(admin string) []string {
+		entries, err := model.ListAuditEntries(db, model.AuditOptions{Admin: admin})
+		assert.NoError(t, err)
+		var names []string
+		for _, e := range entries {
+			names = append(names, fmt.Sprintf("%v %v", e.EntityType, e.EntityID))
+		}
+		return names
+	}
+	assert.Len(t, audit("owner@example.com"), 5)
+	assert.Equal(t, []string{"location 1", "announcement 1"}, audit("viewer@example.com"))
+
+	admins := func(admin string) []string {
+		list, err := model.ListAdmins(db, admin)
+		assert.NoError(t, err)
+		var emails []string
+		for _, a := range list {
+			emails = append(emails, a.Email)
+		}
+		return emails
+	}
+	assert.Equal(t, []string{"owner@example.com", "viewer@example.com"}, admins("owner@example.com"))
+	assert.Equal(t, []string{"viewer@example.com"}, admins("viewer@example.com"))
+
+	db.MustExec(`INSERT INTO users (id, conference_id, device_id, timestamp) VALUES (1, 1, 'device', NOW())`)
+	db.MustExec(`INSERT INTO notifications (user_id, announcement_id, status) VALUES (1, 1, 'Sent'), (1, 4, 'Queued')`)
+	progress := func(admin string) []int {
+		list, err := model.ListNotificationProgress(db, admin)
+		assert.NoError(t, err)
+		var ids []int
+		for _, p := range list {
+			ids = append(ids, p.AnnouncementID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{1, 4}, progress("owner@example.com"))
+	assert.Equal(t, []int{1}, progress("viewer@example.com"))
+
+	// Viewers can't look at other conferences' data.
+	s := &server{db: db, email: "viewer@example.com"}
+	assert.NoError(t, s.authorize(model.RoleViewer, 1))
+	assert.ErrorIs(t, s.authorize(model.RoleViewer, 2), errForbidden)
+}
+
+func TestAuditAdminSave(t *testing.T) {
+	db := newTestDB(t)
+	if err := model.EnsureOwner(db, "owner@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(email, role string) {
+		t.Helper()
+		form := url.Values{"Email": {email}, "Role": {role}}
+		r := httptest.NewRequest("POST", "/admin/admin/save", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s := &server{db: db, w: w, r: r, email: "owner@example.com"}
+		s.adminAdminSave()
+		if w.Code != http.StatusFound {
+			t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	save("viewer@example.com", model.RoleViewer)
+	// Inviting somebody again is audited as a change of their role.
+	save("Viewer@example.com", model.RoleEditor)
+
+	entries, err := model.ListAuditEntries(db, model.AuditOptions{EntityType: model.AuditEntityAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, entries, 2) {
+		return
+	}
+	assert.Equal(t, model.AuditActionUpdate, entries[0].Action)
+	assert.Equal(t, model.AuditActionCreate, entries[1].Action)
+	assert.Equal(t, entries[1].EntityID, entries[0].EntityID)
+	fields, err := entries[0].ChangedFields()
+	assert.NoError(t, err)
+	assert.Equal(t, model.FieldChange{Before: model.RoleViewer, After: model.RoleEditor}, fields["role"])
+}
+
+func TestAuditChangeRollsBack(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Hall', '', '')`)
+
+	// A change that can't be audited isn't made.
+	s := &server{db: db, email: "editor@example.com"}
+	get := func(db model.DB, id string) (interface{}, error) { return "not a struct", nil }
+	del := func(db model.DB) error { return model.DeleteLocation(db, "1") }
+	assert.Error(t, s.auditChange(model.AuditEntityLocation, model.AuditActionDelete, "1", get, del))
+
+	location, err := model.GetLocationByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, location.DeletedAt.Valid)
+}
+
+func TestAuditChanges(t *testing.T) {
+	before := model.Location{ID: 1, Name: "Hall", City: "Berkeley"}
+	after := before
+	after.City = "Oakland"
+
+	changes, err := model.AuditChanges(before, after)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"city": {"before": "Berkeley", "after": "Oakland"}}`, changes)
+
+	entry := model.AuditEntry{Changes: changes}
+	fields, err := entry.ChangedFields()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]model.FieldChange{"city": {Before: "Berkeley", After: "Oakland"}}, fields)
+
+	// Nullable values are recorded as their value, or null.
+	changes, err = model.AuditChanges(nil, model.Admin{ID: 2, Email: "a@example.com", ConferenceID: sql.NullInt64{Int64: 3, Valid: true}})
+	assert.NoError(t, err)
+	entry = model.AuditEntry{Changes: changes}
+	fields, err = entry.ChangedFields()
+	assert.NoError(t, err)
+	assert.Equal(t, model.FieldChange{After: float64(3)}, fields["conference_id"])
+	assert.NotContains(t, fields, "conference_name")
+
+	changes, err = model.AuditChanges(after, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, changes, `"city":{"before":"Oakland","after":null}`)
+
+	_, err = model.AuditChanges("not a struct", nil)
+	assert.Error(t, err)
+}
+
+func TestListAuditEntries(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, e := range []model.AuditEntry{
+		{Actor: "a@example.com", EntityType: model.AuditEntityEvent, EntityID: 1, Action: model.AuditActionCreate, Changes: `{"name": {"before": null, "after": "100% Vegan Lunch"}}`},
+		{Actor: "b@example.com", EntityType: model.AuditEntityEvent, EntityID: 1, Action: model.AuditActionUpdate, Changes: `{"name": {"before": "100% Vegan Lunch", "after": "Lunch"}}`},
+		{Actor: "b@example.com", EntityType: model.AuditEntityLocation, EntityID: 1, Action: model.AuditActionDelete, Changes: `{}`},
+	} {
+		assert.NoError(t, model.InsertAuditEntry(db, e))
+	}
+
+	actions := func(options model.AuditOptions) []string {
+		entries, err := model.ListAuditEntries(db, options)
+		assert.NoError(t, err)
+		var actions []string
+		for _, e := range entries {
+			actions = append(actions, e.EntityType+" "+e.Action)
+		}
+		return actions
+	}
+
+	assert.Equal(t, []string{"location delete", "event update", "event create"}, actions(model.AuditOptions{}))
+	assert.Equal(t, []string{"event update", "event create"}, actions(model.AuditOptions{EntityType: model.AuditEntityEvent, EntityID: 1}))
+	assert.Equal(t, []string{"location delete", "event update"}, actions(model.AuditOptions{Actor: "B@example.com"}))
+	assert.Equal(t, []string{"event update", "event create"}, actions(model.AuditOptions{Search: "vegan"}))
+	assert.Equal(t, []string{"event update", "event create"}, actions(model.AuditOptions{Search: "100%"}))
+	assert.Nil(t, actions(model.AuditOptions{Search: "0%V"}))
+	assert.Equal(t, []string{"location delete"}, actions(model.AuditOptions{Limit: 1}))
+}
+
+func TestTrash(t *testing.T) {
+	db := newTestDB(t)
+	userIDs := insertAnnouncementFixture(t, db, "ExponentPushToken[1]")
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Hall', '', '')`)
+	db.MustExec(`INSERT INTO events (id, conference_id, name, start_time, length, location_id) VALUES (1, 1, 'Lunch', '2021-09-24 12:00:00', 60, 1)`)
+	db.MustExec(`INSERT INTO rsvp (event_id, user_id, attending, timestamp) VALUES (1, ?, 1, NOW())`, userIDs[0])
+
+	var list struct {
+		Events []struct {
+			Name           string `json:"name"`
+			TotalAttendees int    `json:"total_attendees"`
+		} `json:"events"`
+	}
+	listEvents := func() {
+		list.Events = nil
+		callAPI(t, db, apiEventList, `{"conference_id": 1}`, &list)
+	}
+
+	// Deleted events are hidden from the app, but keep their RSVPs.
+	assert.NoError(t, model.DeleteEvent(db, "1"))
+	assert.Error(t, model.DeleteEvent(db, "1"), "event is already in the trash")
+	listEvents()
+	assert.Empty(t, list.Events)
+	events, err := model.ListEvents(db, model.EventOptions{ConferenceId: 1})
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+
+	// The location can be deleted now that no events use it.
+	assert.NoError(t, model.DeleteLocation(db, "1"))
+
+	// Deleted announcements aren't sent.
+	assert.NoError(t, model.DeleteAnnouncement(db, "1"))
+	assert.NoError(t, model.EnqueueAnnouncementNotifications(db))
+	var queued int
+	assert.NoError(t, db.Get(&queued, `SELECT COUNT(*) FROM notifications`))
+	assert.Equal(t, 0, queued)
+
+	trash, err := model.ListTrash(db, "")
+	assert.NoError(t, err)
+	var items []string
+	for _, item := range trash {
+		items = append(items, item.EntityType+" "+item.Name)
+	}
+	assert.ElementsMatch(t, []string{"event Lunch", "location Hall", "announcement Evacuate"}, items)
+
+	// Restoring brings everything back as it was.
+	assert.NoError(t, model.RestoreFromTrash(db, model.AuditEntityEvent, "1"))
+	assert.Error(t, model.RestoreFromTrash(db, model.AuditEntityEvent, "1"), "event is no longer in the trash")
+	listEvents()
+	if assert.Len(t, list.Events, 1) {
+		assert.Equal(t, 1, list.Events[0].TotalAttendees)
+	}
+	assert.NoError(t, model.RestoreFromTrash(db, model.AuditEntityAnnouncement, "1"))
+
+	// Nor are announcements of a conference in the trash.
+	assert.NoError(t, model.DeleteConference(db, "1"))
+	assert.NoError(t, model.EnqueueAnnouncementNotifications(db))
+	assert.NoError(t, db.Get(&queued, `SELECT COUNT(*) FROM notifications`))
+	assert.Equal(t, 0, queued)
+	assert.NoError(t, model.RestoreFromTrash(db, model.AuditEntityConference, "1"))
+	assert.NoError(t, model.EnqueueAnnouncementNotifications(db))
+	assert.NoError(t, db.Get(&queued, `SELECT COUNT(*) FROM notifications`))
+	assert.Equal(t, 1, queued)
+
+	// Only things in the trash can be purged, and purging an event
+	// takes its RSVPs with it.
+	assert.Error(t, model.PurgeFromTrash(db, model.AuditEntityEvent, "1"))
+	assert.Error(t, model.DeleteLocation(db, "1"), "location is used by an event")
+	assert.NoError(t, model.DeleteEvent(db, "1"))
+	assert.NoError(t, model.PurgeFromTrash(db, model.AuditEntityEvent, "1"))
+	var rsvps int
+	assert.NoError(t, db.Get(&rsvps, `SELECT COUNT(*) FROM rsvp`))
+	assert.Equal(t, 0, rsvps)
+	assert.NoError(t, model.PurgeFromTrash(db, model.AuditEntityLocation, "1"))
+
+	// Conferences can't be purged while their data still refers to
+	// them.
+	assert.NoError(t, model.DeleteConference(db, "1"))
+	assert.Error(t, model.PurgeFromTrash(db, model.AuditEntityConference, "1"))
+	assert.Error(t, model.PurgeFromTrash(db, "admin", "1"))
+}
+
+func TestConferenceTimeZone(t *testing.T) {
+	loc, err := model.Conference{Timezone: "Europe/Berlin"}.TimeZone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Europe/Berlin", loc.String())
+
+	// Times are picked in the conference's time zone.
+	start, err := time.ParseInLocation(pickerTimeLayout, "2021-09-24 09:30", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Date(2021, 9, 24, 7, 30, 0, 0, time.UTC), start.UTC())
+
+	for _, bad := range []string{"", "Local", "Pacific", "Mars/Olympus_Mons"} {
+		_, err := model.Conference{Timezone: bad}.TimeZone()
+		assert.Error(t, err, "time zone %q", bad)
+	}
+}
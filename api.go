@@ -1,31 +1,51 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-
-	"github.com/jmoiron/sqlx"
+	"time"
 
 	"github.com/dxe/alc-mobile-api/model"
 )
 
 type api struct {
-	// query is the underlying SQL query that the API issues to the
-	// MySQL database. It should return a single-row, single-column JSON
-	// result.
+	// query is the SQL statement that an API which changes data
+	// executes, with the request's arguments.
 	query string
 
+	// response returns the response of an API which reads data, to be
+	// encoded as JSON. args are the request's arguments, or nil if the
+	// API takes none. Queries should use ctx rather than the request's
+	// context, since cached responses are shared between requests.
+	response func(ctx context.Context, s *server, args interface{}) (interface{}, error)
+
 	// args returns a pointer to a newly allocated variable able to
 	// store the arguments from the JSON request body.
 	args func() interface{}
 
-	// value returns a pointer to a newly allocated Go variable able to
-	// represent the JSON object returned by the query.
-	value func() interface{}
+	// anonymous allows requests without a device token to APIs whose
+	// args embed deviceAuth. For those requests, the user ID is 0.
+	anonymous bool
+
+	// cached APIs have their responses kept in the server's
+	// responseCache for apiCacheTTL.
+	cached bool
+
+	// invalidates lists the cached APIs whose responses change when an
+	// API which changes data succeeds.
+	invalidates []*api
+}
+
+// validator may be implemented by API arguments that need checking
+// beyond what decoding the JSON request body does.
+type validator interface {
+	validate() error
 }
 
 func (a *api) serve(s *server) {
@@ -38,216 +58,517 @@ func (a *api) serve(s *server) {
 			a.error(s, fmt.Errorf("failed to decode json request body: %w", err))
 			return
 		}
+		if v, ok := args.(validator); ok {
+			if err := v.validate(); err != nil {
+				s.apiError(http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+				return
+			}
+		}
+		if d, ok := args.(deviceAuthenticated); ok {
+			err := s.authenticateDevice(d.auth())
+			switch {
+			case err == errNoDeviceToken && a.anonymous:
+			case errors.Is(err, errNoDeviceToken), errors.Is(err, errInvalidDeviceToken):
+				s.apiError(http.StatusUnauthorized, err)
+				return
+			case err != nil:
+				a.error(s, err)
+				return
+			}
+		}
 		queryArgs = args
 	}
 
-	if a.value == nil {
+	if a.response == nil {
 		if _, err := s.db.NamedExecContext(s.r.Context(), a.query, queryArgs); err != nil {
 			a.error(s, err)
+			return
 		}
+		s.cache.invalidate(a.invalidates...)
 		return
 	}
 
-	// TODO(mdempsky): Implement caching and/or single-flighting (e.g.,
-	// golang.org/x/sync/singleflight), so we don't need to issue a DB
-	// request for each HTTP request.
-
-	var buf []byte
-	var result *sqlx.Rows
+	load := func(ctx context.Context) ([]byte, error) {
+		resp, err := a.response(ctx, s, queryArgs)
+		if err != nil {
+			return nil, err
+		}
+		body, err := json.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		return append(body, '\n'), nil
+	}
+	var body []byte
 	var err error
-
-	if queryArgs == nil {
-		result, err = s.db.QueryxContext(s.r.Context(), a.query)
+	if a.cached {
+		body, err = s.cache.get(s.r.Context(), a, responseCacheKey(queryArgs), func() ([]byte, error) {
+			// Requests waiting for the same response share this load,
+			// so it mustn't be canceled along with this request.
+			ctx, cancel := context.WithTimeout(context.Background(), apiCacheLoadTimeout)
+			defer cancel()
+			return load(ctx)
+		})
 	} else {
-		result, err = s.db.NamedQueryContext(s.r.Context(), a.query, queryArgs)
+		body, err = load(s.r.Context())
 	}
-
 	if err != nil {
 		a.error(s, err)
 		return
 	}
-	result.Next()
-	if err := result.Scan(&buf); err != nil {
-		a.error(s, err)
-		return
-	}
-
-	if !*flagProd {
-		// When not in production, check that the SQL response decodes
-		// correctly.
-		// TODO(mdempsky): Replace this with unit tests.
-		if err := json.NewDecoder(bytes.NewReader(buf)).Decode(a.value()); err != nil {
-			log.Printf("failed to decode JSON response: %v", err)
-		}
-	}
-
 	s.w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	s.w.Write(buf)
+	s.w.Write(body)
 }
 
 func (a *api) error(s *server, err error) {
+	s.apiError(http.StatusInternalServerError, err)
+}
+
+func (s *server) apiError(code int, err error) {
 	s.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	s.w.WriteHeader(http.StatusInternalServerError)
+	s.w.WriteHeader(code)
 	io.WriteString(s.w, err.Error())
 }
 
-// TODO(mdempsky): Unit tests to make sure queries below execute and
-// produce valid JSON of the expected schema.
+// The types below are the JSON schema of the public API's responses.
+// They're filled in from the model, so that the model's queries are
+// the only place that knows the database schema. Times are encoded as
+// RFC 3339 timestamps in UTC, and nullable columns as null.
+
+type apiConference struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	// Timezone is the IANA time zone the conference is held in, for
+	// the app to show its times in.
+	Timezone string `json:"timezone"`
+}
+
+func newAPIConference(c model.Conference) apiConference {
+	return apiConference{
+		ID:        c.ID,
+		Name:      c.Name,
+		StartDate: c.StartDate.UTC(),
+		EndDate:   c.EndDate.UTC(),
+		Timezone:  c.Timezone,
+	}
+}
+
+type apiAnnouncement struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	// Message is the announcement's long message. The short one is
+	// only used for push notifications.
+	Message     string     `json:"message"`
+	Icon        string     `json:"icon"`
+	CreatedBy   string     `json:"created_by"`
+	URL         string     `json:"url"`
+	URLText     string     `json:"url_text"`
+	SendTime    time.Time  `json:"send_time"`
+	LinkEventID *int64     `json:"link_event_id"`
+	LinkInfoID  *int64     `json:"link_info_id"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Pinned      bool       `json:"pinned"`
+	Edited      bool       `json:"edited"`
+	Sent        bool       `json:"sent"`
+}
+
+type apiConferenceEvents struct {
+	// Conference is null if there is no such conference.
+	Conference *apiConference `json:"conference"`
+	Events     []apiEvent     `json:"events"`
+}
+
+type apiEvent struct {
+	ID              int         `json:"id"`
+	Name            string      `json:"name"`
+	Description     string      `json:"description"`
+	StartTime       time.Time   `json:"start_time"`
+	Length          int         `json:"length"`
+	KeyEvent        bool        `json:"key_event"`
+	BreakoutSession bool        `json:"breakout_session"`
+	Location        apiLocation `json:"location"`
+	ImageURL        *string     `json:"image_url"`
+	TotalAttendees  int         `json:"total_attendees"`
+	// Attending is false for anonymous requests.
+	Attending bool `json:"attending"`
+}
+
+type apiLocation struct {
+	Name    string   `json:"name"`
+	PlaceID *string  `json:"place_id"`
+	Address string   `json:"address"`
+	City    string   `json:"city"`
+	Lat     *float64 `json:"lat"`
+	Lng     *float64 `json:"lng"`
+}
+
+type apiInfo struct {
+	ID           int     `json:"id"`
+	Title        string  `json:"title"`
+	Subtitle     string  `json:"subtitle"`
+	Content      string  `json:"content"`
+	Icon         string  `json:"icon"`
+	ImageURL     *string `json:"image_url"`
+	DisplayOrder int     `json:"display_order"`
+	KeyInfo      bool    `json:"key_info"`
+}
 
-// TODO(mdempsky): The json_object argument lists are repetitive and
-// somewhat redundant with the Go struct definitions. Can we use
-// reflection to generate them automatically?
+// conferenceArgs are the arguments of APIs that list a conference's
+// data.
+type conferenceArgs struct {
+	ConferenceID int `json:"conference_id"`
+}
 
 var apiAnnouncementList = api{
-	value: func() interface{} { return new([]model.Announcement) },
-	query: `
-select json_arrayagg(json_object(
-  'id',         a.id,
-  'title',      a.title,
-  'message',    a.long_message,
-  'icon',       a.icon,
-  'created_by', a.created_by,
-  'url', 		a.url,
-  'url_text', 	a.url_text,
-  'send_time',  a.send_time,
-  'sent',       a.sent != 0` /* TODO(mdempsky): Change SQL schema to use bool. */ + `
-))
-from announcements a
-where a.sent
-  and a.conference_id = :conference_id
-order by send_time desc
-`,
-	args: func() interface{} {
-		return new(struct {
-			ConferenceID int `json:"conference_id" db:"conference_id"`
-		})
+	response: func(ctx context.Context, s *server, args interface{}) (interface{}, error) {
+		announcements, err := model.ListPublishedAnnouncements(ctx, s.db, args.(*conferenceArgs).ConferenceID)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]apiAnnouncement, len(announcements))
+		for i, a := range announcements {
+			resp[i] = apiAnnouncement{
+				ID:          a.ID,
+				Title:       a.Title,
+				Message:     a.LongMessage,
+				Icon:        a.Icon,
+				CreatedBy:   a.CreatedBy,
+				URL:         a.URL,
+				URLText:     a.URLText,
+				SendTime:    a.SendTime.UTC(),
+				LinkEventID: nullInt64(a.LinkEventID),
+				LinkInfoID:  nullInt64(a.LinkInfoID),
+				ExpiresAt:   nullTime(a.ExpiresAt),
+				Pinned:      a.Pinned,
+				Edited:      a.Edited,
+				Sent:        a.Sent,
+			}
+		}
+		return resp, nil
 	},
+	args: func() interface{} { return new(conferenceArgs) },
 }
 
 var apiConferenceList = api{
-	value: func() interface{} { return new([]model.Conference) },
-	query: `
-select json_arrayagg(json_object(
-  'id',         c.id,
-  'name',       c.name,
-  'start_date', c.start_date,
-  'end_date',   c.end_date
-))
-from conferences c
-order by start_date desc
-`,
+	response: func(_ context.Context, s *server, _ interface{}) (interface{}, error) {
+		conferences, err := model.ListConferences(s.db)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]apiConference, len(conferences))
+		for i, c := range conferences {
+			resp[i] = newAPIConference(c)
+		}
+		return resp, nil
+	},
+	cached: true,
+}
+
+type eventListArgs struct {
+	conferenceArgs
+	deviceAuth
 }
 
 var apiEventList = api{
-	value: func() interface{} { return new([]model.Event) },
-	query: `
-select json_object(
-
-'conference', (select json_object('id', id, 'name', name, 'start_date', start_date, 'end_date', end_date) from conferences where id = :conference_id),
-
-'events', json_arrayagg(json_object(
-  'id',               e.id,
-  'name',             e.name,
-  'description',      e.description,
-  'start_time',       e.start_time,
-  'length',           e.length,
-  'key_event',     	  e.key_event != 0,` /* TODO(mdempsky): Change SQL schema to use bool */ + `
-  'breakout_session', e.breakout_session != 0,` /* TODO(mdempsky): Change SQL schema to use bool */ + `
-  'location',      json_object(
- 		'name', l.name,
-		'place_id', l.place_id,
-		'address', l.address,
-		'city', l.city,
-		'lat', l.lat,
-		'lng', l.lng
-  ),
-  'image_url',     e.image_url,
-  'total_attendees', (
-		select count(distinct rsvpTotal.user_id)
-		from rsvp rsvpTotal
-		where rsvpTotal.event_id = e.id and rsvpTotal.attending
-  ),
-  'attending', (
-		case when(
-			select attending
-			from rsvp rsvpStatus
-			where rsvpStatus.event_id = e.id and rsvpStatus.user_id = (SELECT max(id) FROM users WHERE device_id = :device_id)
-		) then true
-          else false
-          end
-  )
-)))
-from events e
-join locations l on e.location_id = l.id
-where conference_id = :conference_id
-order by e.start_time asc
-`,
-	args: func() interface{} {
-		return new(struct {
-			ConferenceID int    `json:"conference_id" db:"conference_id"`
-			DeviceID     string `json:"device_id" db:"device_id"`
-		})
+	response: func(ctx context.Context, s *server, args interface{}) (interface{}, error) {
+		a := args.(*eventListArgs)
+		var resp apiConferenceEvents
+
+		conference, err := model.GetListedConference(ctx, s.db, a.ConferenceID)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return nil, fmt.Errorf("failed to get conference: %w", err)
+		default:
+			c := newAPIConference(conference)
+			resp.Conference = &c
+		}
+
+		events, err := model.ListEventListings(ctx, s.db, a.ConferenceID, a.UserID)
+		if err != nil {
+			return nil, err
+		}
+		resp.Events = make([]apiEvent, len(events))
+		for i, e := range events {
+			resp.Events[i] = apiEvent{
+				ID:              e.ID,
+				Name:            e.Name,
+				Description:     e.Description,
+				StartTime:       e.StartTime.UTC(),
+				Length:          e.Length,
+				KeyEvent:        e.KeyEvent,
+				BreakoutSession: e.BreakoutSession,
+				Location: apiLocation{
+					Name:    e.Location.Name,
+					PlaceID: nullString(e.Location.PlaceID),
+					Address: e.Location.Address,
+					City:    e.Location.City,
+					Lat:     nullFloat64(e.Location.Lat),
+					Lng:     nullFloat64(e.Location.Lng),
+				},
+				ImageURL:       nullString(e.ImageURL),
+				TotalAttendees: e.TotalAttendees,
+				Attending:      e.Attending,
+			}
+		}
+		return resp, nil
 	},
+	args: func() interface{} { return new(eventListArgs) },
+	// Without a device token, events are listed without the user's
+	// RSVPs.
+	anonymous: true,
+	cached:    true,
 }
 
 var apiInfoList = api{
-	value: func() interface{} { return new([]model.Info) },
-	query: `
-select json_arrayagg(json_object(
-  'id',            i.id,
-  'title',         i.title,
-  'subtitle',      i.subtitle,
-  'content',       i.content,
-  'icon',          i.icon,
-  'image_url',     i.image_url,
-  'display_order', i.display_order,
-  'key_info',      i.key_info
-))
-from info i
-order by i.display_order
-`,
+	response: func(_ context.Context, s *server, _ interface{}) (interface{}, error) {
+		info, err := model.ListInfo(s.db)
+		if err != nil {
+			return nil, err
+		}
+		resp := make([]apiInfo, len(info))
+		for i, in := range info {
+			resp[i] = apiInfo{
+				ID:           in.ID,
+				Title:        in.Title,
+				Subtitle:     in.Subtitle,
+				Content:      in.Content,
+				Icon:         in.Icon,
+				ImageURL:     nullString(in.ImageURL),
+				DisplayOrder: in.DisplayOrder,
+				KeyInfo:      in.KeyInfo,
+			}
+		}
+		return resp, nil
+	},
+	cached: true,
 }
 
-var apiUserAdd = api{
-	query: `
-insert into users (conference_id, name, email, device_id, device_name, platform, timestamp)
-values (:conference_id, :name, :email, :device_id, :device_name, :platform, now())
-on duplicate key update conference_id = VALUES(conference_id), name = VALUES(name), email = VALUES(email)
-`,
-	args: func() interface{} {
-		return new(struct {
-			ConferenceID   int    `json:"conference_id" db:"conference_id"`
-			Name           string `json:"name" db:"name"`
-			Email          string `json:"email" db:"email"`
-			DeviceID       string `json:"device_id" db:"device_id"`
-			DeviceName     string `json:"device_name" db:"device_name"`
-			DevicePlatform string `json:"platform" db:"platform"`
-		})
-	},
+// The null* functions convert nullable values from the model to
+// pointers, which encode as null if they aren't valid.
+
+func nullString(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func nullInt64(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullFloat64(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
+
+func nullTime(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := v.Time.UTC()
+	return &t
+}
+
+// apiUserAdd registers a device's user, and responds with the device
+// token that authenticates the device's other API requests. The new
+// token replaces any that the device was issued before. It doesn't fit
+// the api type because it needs to sign the token in Go.
+func apiUserAdd(s *server) {
+	var args struct {
+		ConferenceID   int    `json:"conference_id"`
+		Name           string `json:"name"`
+		Email          string `json:"email"`
+		DeviceID       string `json:"device_id"`
+		DeviceName     string `json:"device_name"`
+		DevicePlatform string `json:"platform"`
+		// DeviceToken is the token previously issued to the device,
+		// if any.
+		DeviceToken string `json:"device_token"`
+	}
+	if err := json.NewDecoder(s.r.Body).Decode(&args); err != nil {
+		s.apiError(http.StatusInternalServerError, fmt.Errorf("failed to decode json request body: %w", err))
+		return
+	}
+	if args.DeviceID == "" {
+		s.apiError(http.StatusInternalServerError, errors.New("invalid request: device_id must be provided"))
+		return
+	}
+
+	userID, secret, err := model.AddUser(s.db, model.User{
+		ConferenceID: args.ConferenceID,
+		Name:         args.Name,
+		Email:        args.Email,
+		DeviceID:     args.DeviceID,
+		DeviceName:   args.DeviceName,
+		Platform:     args.DevicePlatform,
+	}, func(userID int, tokenUsed bool) error {
+		// Devices from before device tokens can get one with their
+		// device ID alone while legacy device IDs are allowed, and
+		// until they have used it.
+		if args.DeviceToken == "" {
+			if s.legacyDeviceIDs && !tokenUsed {
+				return nil
+			}
+			return errNoDeviceToken
+		}
+		tokenUserID, err := s.verifyDeviceToken(args.DeviceToken)
+		if err != nil {
+			return err
+		}
+		if tokenUserID != userID {
+			return errInvalidDeviceToken
+		}
+		return nil
+	})
+	if errors.Is(err, errNoDeviceToken) || errors.Is(err, errInvalidDeviceToken) {
+		s.apiError(http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		s.apiError(http.StatusInternalServerError, err)
+		return
+	}
+
+	token, err := signDeviceToken(s.deviceTokenKey, userID, secret)
+	if err != nil {
+		s.apiError(http.StatusInternalServerError, err)
+		return
+	}
+	s.w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(s.w).Encode(map[string]string{"device_token": token})
 }
 
 var apiEventRSVP = api{
 	query: `
 replace into rsvp (event_id, user_id, attending, timestamp)
-values (:event_id, (SELECT id FROM users WHERE device_id = :device_id), :attending, now())
+values (:event_id, :user_id, :attending, now())
 `,
 	args: func() interface{} {
 		return new(struct {
-			EventID   int    `json:"event_id" db:"event_id"`
-			DeviceID  string `json:"device_id" db:"device_id"`
-			Attending bool   `json:"attending" db:"attending"`
+			EventID   int  `json:"event_id" db:"event_id"`
+			Attending bool `json:"attending" db:"attending"`
+			deviceAuth
 		})
 	},
+	// Events are listed with their total attendees.
+	invalidates: []*api{&apiEventList},
 }
 
 var apiUserRegisterPushNotifications = api{
 	query: `
-update users set expo_push_token = :expo_push_token where device_id = :device_id
+update users
+set expo_push_token = :expo_push_token, push_token_type = coalesce(nullif(:push_token_type, ''), 'expo')
+where id = :user_id
+`,
+	args: func() interface{} { return new(registerPushNotificationsArgs) },
+}
+
+type registerPushNotificationsArgs struct {
+	deviceAuth
+	ExpoPushToken string `json:"expo_push_token" db:"expo_push_token"`
+	// PushTokenType is "expo" (the default), "fcm", or "apns".
+	PushTokenType string `json:"push_token_type" db:"push_token_type"`
+}
+
+func (p *registerPushNotificationsArgs) validate() error {
+	switch p.PushTokenType {
+	case "", model.PushTokenTypeExpo, model.PushTokenTypeFCM, model.PushTokenTypeAPNs:
+		return nil
+	}
+	return fmt.Errorf("unknown push token type %q", p.PushTokenType)
+}
+
+var apiUserEventReminders = api{
+	query: `
+update users set event_reminders = :enabled where id = :user_id
 `,
 	args: func() interface{} {
 		return new(struct {
-			DeviceID      string `json:"device_id" db:"device_id"`
-			ExpoPushToken string `json:"expo_push_token" db:"expo_push_token"`
+			deviceAuth
+			Enabled bool `json:"enabled" db:"enabled"`
 		})
 	},
 }
+
+var apiUserNotificationPreferences = api{
+	response: func(ctx context.Context, s *server, args interface{}) (interface{}, error) {
+		return model.GetNotificationPreferences(ctx, s.db, args.(*struct{ deviceAuth }).UserID)
+	},
+	args: func() interface{} { return new(struct{ deviceAuth }) },
+}
+
+// apiUserUpdateNotificationPreferences changes the preferences given in
+// the request, and leaves the others as they are. Empty quiet hours
+// clear them.
+var apiUserUpdateNotificationPreferences = api{
+	query: `
+update users
+set event_reminders = coalesce(:event_reminders, event_reminders),
+    muted_announcement_icons = coalesce(:muted_announcement_icons, muted_announcement_icons),
+    quiet_hours_start = if(:quiet_hours_start is null, quiet_hours_start, nullif(:quiet_hours_start, '')),
+    quiet_hours_end = if(:quiet_hours_end is null, quiet_hours_end, nullif(:quiet_hours_end, '')),
+    timezone = coalesce(:timezone, timezone)
+where id = :user_id
+`,
+	args: func() interface{} { return new(notificationPreferencesArgs) },
+}
+
+// notificationPreferencesArgs are nil for preferences that the request
+// leaves unchanged.
+type notificationPreferencesArgs struct {
+	deviceAuth
+	EventReminders         *bool       `json:"event_reminders" db:"event_reminders"`
+	MutedAnnouncementIcons *stringList `json:"muted_announcement_icons" db:"muted_announcement_icons"`
+	QuietHoursStart        *string     `json:"quiet_hours_start" db:"quiet_hours_start"`
+	QuietHoursEnd          *string     `json:"quiet_hours_end" db:"quiet_hours_end"`
+	Timezone               *string     `json:"timezone" db:"timezone"`
+}
+
+func (p *notificationPreferencesArgs) validate() error {
+	if (p.QuietHoursStart == nil) != (p.QuietHoursEnd == nil) {
+		return errors.New("quiet hours need both a start and an end")
+	}
+	if p.QuietHoursStart != nil {
+		start, end := *p.QuietHoursStart, *p.QuietHoursEnd
+		if (start == "") != (end == "") {
+			return errors.New("quiet hours need both a start and an end")
+		}
+		for _, clock := range []string{start, end} {
+			if clock == "" {
+				continue
+			}
+			if _, err := time.Parse("15:04", clock); err != nil {
+				return fmt.Errorf("quiet hours time %q is not HH:MM", clock)
+			}
+		}
+	}
+	if p.Timezone != nil && *p.Timezone != "" {
+		if _, err := time.LoadLocation(*p.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", *p.Timezone)
+		}
+	}
+	return nil
+}
+
+// stringList is a list of strings stored as a JSON array.
+type stringList []string
+
+func (l stringList) Value() (driver.Value, error) {
+	if l == nil {
+		l = stringList{}
+	}
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dxe/alc-mobile-api/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDeviceTokenKey = []byte("test device token key")
+
+// newAPIServer returns a server for handling a public API request with
+// the given JSON body.
+func newAPIServer(db *sqlx.DB, w http.ResponseWriter, body string) *server {
+	return &server{
+		db:             db,
+		w:              w,
+		r:              httptest.NewRequest("POST", "/", strings.NewReader(body)),
+		deviceTokenKey: testDeviceTokenKey,
+	}
+}
+
+// testDeviceToken gives the user a device secret, and returns the
+// device token signed with it.
+func testDeviceToken(t *testing.T, db *sqlx.DB, userID int) string {
+	t.Helper()
+	secret := fmt.Sprintf("secret-%d", userID)
+	db.MustExec(`UPDATE users SET device_secret = ? WHERE id = ?`, secret, userID)
+	token, err := signDeviceToken(testDeviceTokenKey, userID, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// callAPI serves a request to a with the given JSON body and decodes
+// the response into v.
+func callAPI(t *testing.T, db *sqlx.DB, a api, body string, v interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	a.serve(newAPIServer(db, w, body))
+	if w.Code != 200 {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestAnnouncementListExpiryAndPinning(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	db.MustExec(`
+INSERT INTO announcements (id, conference_id, title, message, long_message, icon, url, url_text, created_by, send_time, sent, expires_at, pinned)
+VALUES
+	(1, 1, 'Old', '', '', 'newspaper', '', '', 'test@example.com', UTC_TIMESTAMP - INTERVAL 3 HOUR, 1, NULL, 0),
+	(2, 1, 'Pinned', '', '', 'newspaper', '', '', 'test@example.com', UTC_TIMESTAMP - INTERVAL 2 HOUR, 1, NULL, 1),
+	(3, 1, 'Expired', '', '', 'newspaper', '', '', 'test@example.com', UTC_TIMESTAMP - INTERVAL 2 HOUR, 1, UTC_TIMESTAMP - INTERVAL 1 HOUR, 0),
+	(4, 1, 'New', '', '', 'newspaper', '', '', 'test@example.com', UTC_TIMESTAMP - INTERVAL 1 HOUR, 1, UTC_TIMESTAMP + INTERVAL 1 HOUR, 0)
+`)
+
+	var announcements []struct {
+		Title string `json:"title"`
+	}
+	callAPI(t, db, apiAnnouncementList, `{"conference_id": 1}`, &announcements)
+
+	var titles []string
+	for _, a := range announcements {
+		titles = append(titles, a.Title)
+	}
+	assert.Equal(t, []string{"Pinned", "New", "Old"}, titles)
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[muted]", "ExponentPushToken[ok]")
+
+	token := testDeviceToken(t, db, users[0])
+	update := `{
+		"device_token": "` + token + `",
+		"event_reminders": false,
+		"muted_announcement_icons": ["exclamation-triangle"],
+		"quiet_hours_start": "22:00",
+		"quiet_hours_end": "07:00",
+		"timezone": "America/Los_Angeles"
+	}`
+	w := httptest.NewRecorder()
+	apiUserUpdateNotificationPreferences.serve(newAPIServer(db, w, update))
+	assert.Equal(t, 200, w.Code, w.Body.String())
+
+	var prefs struct {
+		EventReminders         bool     `json:"event_reminders"`
+		MutedAnnouncementIcons []string `json:"muted_announcement_icons"`
+		QuietHoursStart        string   `json:"quiet_hours_start"`
+		QuietHoursEnd          string   `json:"quiet_hours_end"`
+		Timezone               string   `json:"timezone"`
+	}
+	callAPI(t, db, apiUserNotificationPreferences, `{"device_token": "`+token+`"}`, &prefs)
+	assert.Equal(t, []string{"exclamation-triangle"}, prefs.MutedAnnouncementIcons)
+	assert.Equal(t, "22:00", prefs.QuietHoursStart)
+	assert.Equal(t, "07:00", prefs.QuietHoursEnd)
+	assert.Equal(t, "America/Los_Angeles", prefs.Timezone)
+
+	// The fixture announcement uses the muted icon.
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	var queued []int
+	if err := db.Select(&queued, `SELECT user_id FROM notifications`); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{users[1]}, queued)
+
+	// Preferences left out of an update keep their values.
+	w = httptest.NewRecorder()
+	partial := `{"device_token": "` + token + `", "event_reminders": true, "quiet_hours_start": "", "quiet_hours_end": ""}`
+	apiUserUpdateNotificationPreferences.serve(newAPIServer(db, w, partial))
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	prefs.MutedAnnouncementIcons = nil
+	callAPI(t, db, apiUserNotificationPreferences, `{"device_token": "`+token+`"}`, &prefs)
+	assert.True(t, prefs.EventReminders)
+	assert.Equal(t, []string{"exclamation-triangle"}, prefs.MutedAnnouncementIcons)
+	assert.Equal(t, "", prefs.QuietHoursStart)
+	assert.Equal(t, "", prefs.QuietHoursEnd)
+	assert.Equal(t, "America/Los_Angeles", prefs.Timezone)
+
+	for _, invalid := range []string{
+		`"quiet_hours_start": "22:00"`,
+		`"quiet_hours_start": "22:00", "quiet_hours_end": ""`,
+		`"quiet_hours_start": "10pm", "quiet_hours_end": "7am"`,
+		`"timezone": "Mars/Olympus_Mons"`,
+	} {
+		w = httptest.NewRecorder()
+		apiUserUpdateNotificationPreferences.serve(newAPIServer(db, w, `{"device_token": "`+token+`", `+invalid+`}`))
+		assert.Equal(t, http.StatusBadRequest, w.Code, invalid)
+	}
+}
+
+func TestRegisterPushNotifications(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[old]")
+	token := testDeviceToken(t, db, users[0])
+
+	register := func(body string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		apiUserRegisterPushNotifications.serve(newAPIServer(db, w, `{"device_token": "`+token+`", `+body+`}`))
+		return w.Code
+	}
+	assert.Equal(t, 200, register(`"expo_push_token": "fcm-token", "push_token_type": "fcm"`))
+	var user struct {
+		Token string `db:"expo_push_token"`
+		Type  string `db:"push_token_type"`
+	}
+	if err := db.Get(&user, `SELECT expo_push_token, push_token_type FROM users WHERE id = ?`, users[0]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "fcm-token", user.Token)
+	assert.Equal(t, model.PushTokenTypeFCM, user.Type)
+
+	// Tokens of a type we can't deliver to are refused, rather than
+	// stored and never sent to.
+	assert.Equal(t, http.StatusBadRequest, register(`"expo_push_token": "token", "push_token_type": "web"`))
+	assert.Equal(t, http.StatusBadRequest, register(`"expo_push_token": "token", "push_token_type": "huawei-push-kit"`))
+	if err := db.Get(&user, `SELECT expo_push_token, push_token_type FROM users WHERE id = ?`, users[0]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, model.PushTokenTypeFCM, user.Type)
+}
+
+func TestDeviceToken(t *testing.T) {
+	secrets := map[int]string{42: "secret"}
+	getSecret := func(userID int) (string, error) {
+		secret, ok := secrets[userID]
+		if !ok {
+			return "", sql.ErrNoRows
+		}
+		return secret, nil
+	}
+
+	token, err := signDeviceToken(testDeviceTokenKey, 42, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, err := verifyDeviceToken(testDeviceTokenKey, token, getSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, userID)
+
+	for _, bad := range []string{
+		"",
+		"42",
+		"43" + token[2:],
+		token + "x",
+	} {
+		_, err := verifyDeviceToken(testDeviceTokenKey, bad, getSecret)
+		assert.ErrorIs(t, err, errInvalidDeviceToken, "token %q", bad)
+	}
+
+	_, err = verifyDeviceToken([]byte("some other key"), token, getSecret)
+	assert.ErrorIs(t, err, errInvalidDeviceToken)
+
+	// Replacing the user's secret revokes the token.
+	secrets[42] = "new secret"
+	_, err = verifyDeviceToken(testDeviceTokenKey, token, getSecret)
+	assert.ErrorIs(t, err, errInvalidDeviceToken)
+
+	_, err = signDeviceToken(nil, 42, "secret")
+	assert.Error(t, err, "signing without a key should fail")
+	_, err = signDeviceToken(testDeviceTokenKey, 42, "")
+	assert.Error(t, err, "signing without a secret should fail")
+}
+
+func TestDeviceAuthentication(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Main Hall', '1 Main St', 'Oakland')`)
+	db.MustExec(`INSERT INTO events (id, conference_id, name, start_time, length, location_id) VALUES (1, 1, 'Workshop', '2021-09-25 10:00:00', 60, 1)`)
+	db.MustExec(`INSERT INTO users (id, conference_id, device_id, timestamp) VALUES (1, 1, 'legacy-device', NOW())`)
+
+	addUser := func(body string, legacyDeviceIDs bool) (int, string) {
+		w := httptest.NewRecorder()
+		s := newAPIServer(db, w, body)
+		s.legacyDeviceIDs = legacyDeviceIDs
+		apiUserAdd(s)
+		var resp struct {
+			DeviceToken string `json:"device_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.DeviceToken
+	}
+	rsvp := func(body string, legacyDeviceIDs bool) int {
+		w := httptest.NewRecorder()
+		s := newAPIServer(db, w, body)
+		s.legacyDeviceIDs = legacyDeviceIDs
+		apiEventRSVP.serve(s)
+		return w.Code
+	}
+
+	// New installs get a token, and must use it.
+	code, token := addUser(`{"conference_id": 1, "device_id": "new-device"}`, false)
+	assert.Equal(t, 200, code)
+	userID, err := newAPIServer(db, nil, "").verifyDeviceToken(token)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 200, rsvp(`{"event_id": 1, "attending": true, "device_token": "`+token+`"}`, false))
+	assert.Equal(t, 401, rsvp(`{"event_id": 1, "attending": true, "device_id": "new-device"}`, false))
+	assert.Equal(t, 401, rsvp(`{"event_id": 1, "attending": true, "device_token": "1.forged"}`, false))
+
+	var rsvps []int
+	if err := db.Select(&rsvps, `SELECT user_id FROM rsvp`); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []int{userID}, rsvps)
+
+	// Nobody can take a device over by registering its device ID
+	// again, unless legacy device IDs are allowed and the device hasn't
+	// used a token.
+	code, _ = addUser(`{"conference_id": 1, "device_id": "new-device"}`, false)
+	assert.Equal(t, 401, code)
+	code, _ = addUser(`{"conference_id": 1, "device_id": "new-device"}`, true)
+	assert.Equal(t, 401, code)
+	code, _ = addUser(`{"conference_id": 1, "device_id": "legacy-device"}`, false)
+	assert.Equal(t, 401, code)
+
+	// Registering again with the token replaces it.
+	code, token2 := addUser(`{"conference_id": 1, "device_id": "new-device", "device_token": "`+token+`"}`, false)
+	assert.Equal(t, 200, code)
+	assert.NotEqual(t, token, token2)
+	assert.Equal(t, 401, rsvp(`{"event_id": 1, "attending": true, "device_token": "`+token+`"}`, false))
+	token = token2
+
+	// Existing installs keep working by device ID while legacy device
+	// IDs are allowed.
+	assert.Equal(t, 401, rsvp(`{"event_id": 1, "attending": true, "device_id": "legacy-device"}`, false))
+	assert.Equal(t, 200, rsvp(`{"event_id": 1, "attending": true, "device_id": "legacy-device"}`, true))
+	assert.Equal(t, 401, rsvp(`{"event_id": 1, "attending": true, "device_id": "new-device"}`, true),
+		"devices that have used a token can't fall back to their device ID")
+
+	// They can get a token with their device ID while legacy device
+	// IDs are allowed.
+	code, legacyToken := addUser(`{"conference_id": 1, "device_id": "legacy-device"}`, true)
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 200, rsvp(`{"event_id": 1, "attending": true, "device_token": "`+legacyToken+`"}`, false))
+
+	// The personalized event list falls back to no RSVPs without a
+	// token.
+	var list struct {
+		Events []struct {
+			Attending bool `json:"attending"`
+		} `json:"events"`
+	}
+	callAPI(t, db, apiEventList, `{"conference_id": 1}`, &list)
+	assert.False(t, list.Events[0].Attending)
+	callAPI(t, db, apiEventList, `{"conference_id": 1, "device_token": "`+token+`"}`, &list)
+	assert.True(t, list.Events[0].Attending)
+}
+
+func TestEventListEncoding(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Main Hall', '1 Main St', 'Oakland')`)
+	db.MustExec(`
+INSERT INTO events (id, conference_id, name, start_time, length, location_id, key_event, breakout_session)
+VALUES (1, 1, 'Workshop', '2021-09-25 17:30:00', 60, 1, TRUE, FALSE)
+`)
+
+	// Times are RFC 3339, and flags are JSON booleans rather than 0
+	// or 1.
+	var list struct {
+		Conference struct {
+			StartDate string `json:"start_date"`
+			EndDate   string `json:"end_date"`
+			Timezone  string `json:"timezone"`
+		} `json:"conference"`
+		Events []struct {
+			StartTime       string `json:"start_time"`
+			KeyEvent        bool   `json:"key_event"`
+			BreakoutSession bool   `json:"breakout_session"`
+		} `json:"events"`
+	}
+	callAPI(t, db, apiEventList, `{"conference_id": 1}`, &list)
+	assert.Equal(t, "2021-09-24T00:00:00Z", list.Conference.StartDate)
+	assert.Equal(t, "2021-09-30T00:00:00Z", list.Conference.EndDate)
+	assert.Equal(t, model.DefaultTimezone, list.Conference.Timezone)
+	if len(list.Events) != 1 {
+		t.Fatalf("got %d events, want 1", len(list.Events))
+	}
+	assert.Equal(t, "2021-09-25T17:30:00Z", list.Events[0].StartTime)
+	assert.True(t, list.Events[0].KeyEvent)
+	assert.False(t, list.Events[0].BreakoutSession)
+
+	event, err := model.GetEventByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, time.Date(2021, 9, 25, 17, 30, 0, 0, time.UTC), event.StartTime)
+}
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of API responses")
+
+// TestAPIGolden pins the JSON schema of each public API's response to
+// a golden file in testdata/api. After changing a schema on purpose,
+// rewrite the files by running the test with -update.
+func TestAPIGolden(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`
+INSERT INTO conferences (id, name, start_date, end_date, timezone)
+VALUES
+	(1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00', 'America/Los_Angeles'),
+	(2, 'Next Conference', '2022-09-23 00:00:00', '2022-09-29 00:00:00', 'Europe/Berlin')
+`)
+	db.MustExec(`
+INSERT INTO locations (id, name, place_id, address, city, lat, lng)
+VALUES
+	(1, 'Main Hall', 'place-main-hall', '1 Main St', 'Oakland', 37.5, -122.25),
+	(2, 'Annex', NULL, '2 Side St', 'Oakland', NULL, NULL)
+`)
+	db.MustExec(`
+INSERT INTO events (id, conference_id, name, description, start_time, length, location_id, image_url, key_event, breakout_session, deleted_at)
+VALUES
+	(1, 1, 'Workshop', 'Learn things', '2021-09-25 17:30:00', 60, 1, 'https://example.com/workshop.jpg', TRUE, FALSE, NULL),
+	(2, 1, 'Breakout', 'Talk in groups', '2021-09-25 19:00:00', 30, 2, NULL, FALSE, TRUE, NULL),
+	(3, 1, 'Trashed', 'Gone', '2021-09-25 20:00:00', 30, 1, NULL, FALSE, FALSE, '2021-09-01 00:00:00')
+`)
+	db.MustExec(`
+INSERT INTO users (id, conference_id, device_id, timestamp, event_reminders, muted_announcement_icons, quiet_hours_start, quiet_hours_end, timezone)
+VALUES
+	(1, 1, 'device-1', NOW(), FALSE, '["newspaper"]', '22:00:00', '07:00:00', 'America/Los_Angeles'),
+	(2, 1, 'device-2', NOW(), TRUE, NULL, NULL, NULL, '')
+`)
+	db.MustExec(`INSERT INTO rsvp (event_id, user_id, attending, timestamp) VALUES (1, 1, TRUE, NOW()), (1, 2, TRUE, NOW()), (2, 2, FALSE, NOW())`)
+	db.MustExec(`
+INSERT INTO info (id, title, subtitle, content, icon, display_order, image_url, key_info)
+VALUES
+	(1, 'Schedule', 'When things happen', 'The schedule', 'calendar', 1, NULL, TRUE),
+	(2, 'Venue', 'Where things happen', 'Directions', 'map', 2, 'https://example.com/venue.jpg', FALSE)
+`)
+	db.MustExec(`
+INSERT INTO announcements (id, conference_id, title, message, long_message, icon, url, url_text, created_by, state, send_time, sent, retracted, expires_at, pinned, link_event_id, link_info_id)
+VALUES
+	(1, 1, 'Welcome', 'Welcome!', 'Welcome to the conference', 'newspaper', 'https://example.com', 'More', 'test@example.com', 'approved', '2021-09-24 16:00:00', TRUE, FALSE, NULL, FALSE, 1, NULL),
+	(2, 1, 'Pinned', 'Read me', 'Read me first', 'exclamation-triangle', '', '', 'test@example.com', 'approved', '2021-09-24 15:00:00', TRUE, FALSE, '2099-01-01 00:00:00', TRUE, NULL, 2),
+	(3, 1, 'Retracted', 'Oops', 'Never mind', 'newspaper', '', '', 'test@example.com', 'approved', '2021-09-24 17:00:00', TRUE, TRUE, NULL, FALSE, NULL, NULL)
+`)
+	db.MustExec(`
+INSERT INTO announcement_revisions (announcement_id, action, title, message, long_message, url, url_text, created_by)
+VALUES (1, 'edit', 'Welcome', 'Welcome', 'Welcome!', 'https://example.com', 'More', 'test@example.com')
+`)
+
+	token := testDeviceToken(t, db, 1)
+	for _, tt := range []struct {
+		name string
+		api  api
+		body string
+	}{
+		{"announcement_list", apiAnnouncementList, `{"conference_id": 1}`},
+		{"conference_list", apiConferenceList, ``},
+		{"event_list", apiEventList, `{"conference_id": 1, "device_token": "` + token + `"}`},
+		{"event_list_anonymous", apiEventList, `{"conference_id": 1}`},
+		{"event_list_missing_conference", apiEventList, `{"conference_id": 3}`},
+		{"info_list", apiInfoList, ``},
+		{"user_notification_preferences", apiUserNotificationPreferences, `{"device_token": "` + token + `"}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.api.serve(newAPIServer(db, w, tt.body))
+			if w.Code != 200 {
+				t.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+			}
+
+			golden := filepath.Join("testdata", "api", tt.name+".json")
+			if *updateGolden {
+				var out bytes.Buffer
+				if err := json.Indent(&out, w.Body.Bytes(), "", "  "); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.JSONEq(t, string(want), w.Body.String())
+		})
+	}
+}
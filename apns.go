@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dxe/alc-mobile-api/model"
+)
+
+const (
+	apnsProductionHost  = "https://api.push.apple.com"
+	apnsDevelopmentHost = "https://api.sandbox.push.apple.com"
+
+	// Apple rejects provider tokens older than an hour, and throttles
+	// clients that refresh them more often than every 20 minutes.
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsClient sends notifications to iOS devices through the Apple Push
+// Notification service, authenticating with a provider token signed by
+// a .p8 key.
+type APNsClient struct {
+	host       string
+	keyID      string
+	teamID     string
+	topic      string
+	key        *ecdsa.PrivateKey
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsClient returns an APNsClient. keyPEM is the contents of the
+// .p8 signing key, and topic is the app's bundle ID.
+func NewAPNsClient(keyPEM []byte, keyID, teamID, topic string, production bool) (*APNsClient, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode APNs key PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse APNs key: %w", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("APNs key is not an ECDSA key")
+	}
+
+	host := apnsDevelopmentHost
+	if production {
+		host = apnsProductionHost
+	}
+
+	return &APNsClient{
+		host:   host,
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		key:    key,
+		// The default transport negotiates HTTP/2 over TLS, which APNs
+		// requires.
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// providerToken returns a signed ES256 JWT for authenticating with
+// APNs, reusing the previous token until it is close to expiring.
+func (c *APNsClient) providerToken(now time.Time) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && now.Sub(c.issuedAt) < apnsTokenLifetime {
+		return c.token, nil
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": c.keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{"iss": c.teamID, "iat": now.Unix()})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	// JWS encodes ES256 signatures as the fixed-width concatenation of
+	// r and s.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	c.token = signingInput + "." + enc.EncodeToString(sig)
+	c.issuedAt = now
+	return c.token, nil
+}
+
+func (c *APNsClient) Send(ctx context.Context, n model.Notification) error {
+	token, err := c.providerToken(time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to sign APNs provider token: %w", err)
+	}
+
+	type alert struct {
+		Title string `json:"title,omitempty"`
+		Body  string `json:"body"`
+	}
+	aps := map[string]interface{}{
+		"alert": alert{Title: n.Title, Body: n.Body},
+	}
+	if n.Badge.Valid {
+		aps["badge"] = n.Badge.Int64
+	}
+	if n.Sound != "" {
+		aps["sound"] = n.Sound
+	}
+	// Custom data goes alongside aps at the top level of the payload.
+	payload := map[string]interface{}{"aps": aps}
+	for k, v := range notificationData(n) {
+		payload[k] = v
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.host+"/3/device/"+n.ExpoPushToken, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", c.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("Content-Type", "application/json")
+	if n.Priority == "normal" {
+		req.Header.Set("apns-priority", "5")
+	} else {
+		req.Header.Set("apns-priority", "10")
+	}
+	if n.TTLSeconds > 0 {
+		expiration := time.Now().Add(time.Duration(n.TTLSeconds) * time.Second)
+		req.Header.Set("apns-expiration", strconv.FormatInt(expiration.Unix(), 10))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return apnsError(resp)
+}
+
+// apnsError converts an APNs error response into an error suitable for
+// returning from Send.
+//
+// See https://developer.apple.com/documentation/usernotifications/setting_up_a_remote_notification_server/handling_notification_responses_from_apns.
+func apnsError(resp *http.Response) error {
+	var r struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&r)
+	msg := fmt.Sprintf("apns: %d %s", resp.StatusCode, r.Reason)
+
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		r.Reason == "BadDeviceToken", r.Reason == "DeviceTokenNotForTopic", r.Reason == "Unregistered":
+		return ErrUnregistered
+	case resp.StatusCode == http.StatusRequestEntityTooLarge,
+		resp.StatusCode == http.StatusBadRequest:
+		return &PermanentPushError{Reason: msg}
+	}
+	// Authentication problems (403), throttling (429), and server
+	// errors may all resolve themselves, so retry.
+	return errors.New(msg)
+}
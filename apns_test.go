@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dxe/alc-mobile-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPNsKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// verifyAPNsToken checks that token is an ES256 JWT signed by key.
+func verifyAPNsToken(t *testing.T, key *ecdsa.PrivateKey, token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	return ecdsa.Verify(&key.PublicKey, digest[:], r, s)
+}
+
+func TestAPNsClientSend(t *testing.T) {
+	key, keyPEM := newTestAPNsKey(t)
+
+	var devices []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.ProtoAtLeast(2, 0) {
+			t.Errorf("expected HTTP/2 request, got %v", r.Proto)
+		}
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+		if !verifyAPNsToken(t, key, auth) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"reason": "InvalidProviderToken"})
+			return
+		}
+		assert.Equal(t, "org.example.app", r.Header.Get("apns-topic"))
+
+		device := strings.TrimPrefix(r.URL.Path, "/3/device/")
+		devices = append(devices, device)
+
+		var body struct {
+			APS struct {
+				Alert struct {
+					Title string `json:"title"`
+					Body  string `json:"body"`
+				} `json:"alert"`
+			} `json:"aps"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		assert.Equal(t, "Title", body.APS.Alert.Title)
+
+		switch device {
+		case "gone":
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(map[string]string{"reason": "Unregistered"})
+		case "toobig":
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(map[string]string{"reason": "PayloadTooLarge"})
+		case "busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"reason": "TooManyRequests"})
+		}
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	client, err := NewAPNsClient(keyPEM, "KEYID", "TEAMID", "org.example.app", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.host = srv.URL
+	client.httpClient = srv.Client()
+
+	ctx := context.Background()
+	send := func(token string) error {
+		return client.Send(ctx, model.Notification{ExpoPushToken: token, Title: "Title", Body: "Body"})
+	}
+
+	assert.NoError(t, send("ok"))
+	assert.ErrorIs(t, send("gone"), ErrUnregistered)
+
+	var permanent *PermanentPushError
+	assert.ErrorAs(t, send("toobig"), &permanent)
+
+	err = send("busy")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnregistered)
+	assert.False(t, errors.As(err, &permanent))
+
+	assert.Equal(t, []string{"ok", "gone", "toobig", "busy"}, devices)
+}
+
+func TestAPNsProviderTokenReuse(t *testing.T) {
+	_, keyPEM := newTestAPNsKey(t)
+	client, err := NewAPNsClient(keyPEM, "KEYID", "TEAMID", "org.example.app", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	first, err := client.providerToken(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := client.providerToken(now.Add(10 * time.Minute))
+	assert.Equal(t, first, second)
+	third, _ := client.providerToken(now.Add(apnsTokenLifetime))
+	assert.NotEqual(t, first, third)
+}
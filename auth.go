@@ -3,13 +3,16 @@ package main
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/coreos/go-oidc"
+	"github.com/dxe/alc-mobile-api/model"
 	"golang.org/x/oauth2"
 )
 
@@ -17,6 +20,7 @@ import (
 const (
 	cookieIDToken   = "api_id_token"
 	cookieAuthState = "api_auth_state"
+	cookieCSRFToken = "api_csrf_token"
 )
 
 func newGoogleVerifier(clientID, clientSecret string) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
@@ -37,9 +41,33 @@ func newGoogleVerifier(clientID, clientSecret string) (*oauth2.Config, *oidc.IDT
 	return conf, verifier, nil
 }
 
-func isAdmin(email string) bool {
-	return email == "matthew@dempsky.org" ||
-		strings.HasSuffix(email, "@directactioneverywhere.com")
+// errForbidden is returned when an admin lacks the role needed for
+// what they tried to do.
+var errForbidden = errors.New("forbidden")
+
+// authorize returns an error wrapping errForbidden unless the logged
+// in admin has at least role for the conference. A conferenceID of 0
+// requires the role for all conferences, as for data like locations
+// that conferences share.
+func (s *server) authorize(role string, conferenceID int) error {
+	have, err := model.GetAdminRole(s.db, s.email, conferenceID)
+	if err != nil {
+		return err
+	}
+	if !model.RoleAtLeast(have, role) {
+		if conferenceID == 0 {
+			return fmt.Errorf("%w: you need to be %v of all conferences to do this", errForbidden, roleWithArticle(role))
+		}
+		return fmt.Errorf("%w: you need to be %v of this conference to do this", errForbidden, roleWithArticle(role))
+	}
+	return nil
+}
+
+func roleWithArticle(role string) string {
+	if strings.ContainsAny(role[:1], "aeiou") {
+		return "an " + role
+	}
+	return "a " + role
 }
 
 func (s *server) googleEmail() (string, error) {
@@ -69,6 +97,46 @@ func (s *server) googleEmail() (string, error) {
 	return claims.Email, nil
 }
 
+// loadCSRFToken sets s.csrfToken to the session's CSRF token, starting
+// a new session token if the request doesn't have one. Admin pages
+// include the token in their forms, and checkCSRFToken requires it on
+// requests that change data, so that other sites can't make them on
+// an admin's behalf.
+func (s *server) loadCSRFToken() error {
+	if c, err := s.r.Cookie(cookieCSRFToken); err == nil && c.Value != "" {
+		s.csrfToken = c.Value
+		return nil
+	}
+	return s.newCSRFToken()
+}
+
+// newCSRFToken starts a new session CSRF token.
+func (s *server) newCSRFToken() error {
+	token, err := nonce()
+	if err != nil {
+		return err
+	}
+	http.SetCookie(s.w, &http.Cookie{
+		Name:     cookieCSRFToken,
+		Value:    token,
+		MaxAge:   36000,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+	})
+	s.csrfToken = token
+	return nil
+}
+
+// checkCSRFToken returns an error wrapping errForbidden unless the
+// request's csrf_token form value matches the session's CSRF token.
+func (s *server) checkCSRFToken() error {
+	token := s.r.PostFormValue("csrf_token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.csrfToken)) != 1 {
+		return fmt.Errorf("%w: the form has expired; reload the page and try again", errForbidden)
+	}
+	return nil
+}
+
 func (s *server) login() {
 	state, err := nonce()
 	if err != nil {
@@ -105,6 +173,10 @@ func (s *server) logout() {
 		Name:   cookieIDToken,
 		MaxAge: -1,
 	})
+	http.SetCookie(s.w, &http.Cookie{
+		Name:   cookieCSRFToken,
+		MaxAge: -1,
+	})
 
 	s.redirect(absURL("/admin"))
 }
@@ -134,6 +206,11 @@ func (s *server) auth() {
 		SameSite: http.SameSiteLaxMode,
 		HttpOnly: true,
 	})
+	// Start a new CSRF token with each session.
+	if err := s.newCSRFToken(); err != nil {
+		s.serveJSON(nil, err)
+		return
+	}
 	s.redirect(absURL("/admin"))
 }
 
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRFToken(t *testing.T) {
+	// A request without a token cookie starts a new session token.
+	w := httptest.NewRecorder()
+	s := &server{w: w, r: httptest.NewRequest("GET", "/admin", nil)}
+	assert.NoError(t, s.loadCSRFToken())
+	assert.NotEmpty(t, s.csrfToken)
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, cookieCSRFToken, cookies[0].Name)
+		assert.Equal(t, s.csrfToken, cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+	}
+	token := s.csrfToken
+
+	post := func(form url.Values) error {
+		r := httptest.NewRequest("POST", "/admin/event/delete", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: cookieCSRFToken, Value: token})
+		w := httptest.NewRecorder()
+		s := &server{w: w, r: r}
+		assert.NoError(t, s.loadCSRFToken())
+		assert.Equal(t, token, s.csrfToken)
+		assert.Empty(t, w.Result().Cookies(), "existing token should be kept")
+		return s.checkCSRFToken()
+	}
+
+	assert.NoError(t, post(url.Values{"csrf_token": {token}, "id": {"1"}}))
+	assert.True(t, errors.Is(post(url.Values{"id": {"1"}}), errForbidden))
+	assert.True(t, errors.Is(post(url.Values{"csrf_token": {token + "0"}}), errForbidden))
+
+	// The token must be in the request body, not the URL.
+	r := httptest.NewRequest("POST", "/admin/event/delete?csrf_token="+token, nil)
+	s = &server{w: httptest.NewRecorder(), r: r, csrfToken: token}
+	assert.True(t, errors.Is(s.checkCSRFToken(), errForbidden))
+}
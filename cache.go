@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// apiCacheTTL is how long cached API responses are served for. Changes
+// made from the admin site and RSVPs invalidate them sooner, but only
+// on the server that handled the change, so this bounds how stale the
+// other servers' responses get.
+const apiCacheTTL = 15 * time.Second
+
+// apiCacheLoadTimeout bounds the queries for cached API responses, which
+// aren't canceled along with the request that started them.
+const apiCacheLoadTimeout = 30 * time.Second
+
+// minCacheSweep is the number of cached responses below which expired
+// responses are left in place until they are requested again.
+const minCacheSweep = 1000
+
+// responseCache holds the encoded responses of APIs that every app
+// launch requests, keyed by API and arguments. Concurrent requests for
+// a response that isn't cached wait for the same DB query. The methods
+// of a nil *responseCache don't cache anything.
+type responseCache struct {
+	// hits and misses count the requests served from the cache and
+	// the requests that had to wait for a query. They are accessed
+	// atomically, so they come first to be 64-bit aligned.
+	hits, misses uint64
+
+	ttl   time.Duration
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[*api]map[string]cachedResponse
+	// generation is incremented by each invalidation, so that responses
+	// queried before it are neither cached nor shared after it.
+	generation uint64
+	// sweepAt is the number of cached responses at which the expired
+	// ones are next dropped.
+	sweepAt int
+}
+
+type cachedResponse struct {
+	body    []byte
+	expires time.Time
+}
+
+// cacheStats are the counters shown on the admin site.
+type cacheStats struct {
+	Hits, Misses uint64
+	Entries      int
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		entries: make(map[*api]map[string]cachedResponse),
+		sweepAt: minCacheSweep,
+	}
+}
+
+// get returns a's cached response for key, or else the response
+// returned by load, which is cached if it succeeds. Concurrent calls
+// for the same response share one call of load, and stop waiting for
+// it if ctx is done.
+func (c *responseCache) get(ctx context.Context, a *api, key string, load func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return load()
+	}
+
+	body, generation, ok := c.lookup(a, key)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+		return body, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	ch := c.group.DoChan(fmt.Sprintf("%p %v %v", a, generation, key), func() (interface{}, error) {
+		// The query we missed may have just finished.
+		if body, _, ok := c.lookup(a, key); ok {
+			return body, nil
+		}
+		body, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.put(a, key, body, generation)
+		return body, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookup returns a's cached response for key, if any, and the current
+// generation.
+func (c *responseCache) lookup(a *api, key string) ([]byte, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, ok := c.entries[a][key]
+	if !ok || !time.Now().Before(resp.expires) {
+		return nil, c.generation, false
+	}
+	return resp.body, c.generation, true
+}
+
+// put caches a's response for key, unless the cache has been
+// invalidated since generation.
+func (c *responseCache) put(a *api, key string, body []byte, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return
+	}
+
+	now := time.Now()
+	if c.entries[a] == nil {
+		c.entries[a] = make(map[string]cachedResponse)
+	}
+	c.entries[a][key] = cachedResponse{body: body, expires: now.Add(c.ttl)}
+
+	// Event lists are cached per user, so drop the responses of users
+	// who don't come back every so often.
+	if n := c.len(); n >= c.sweepAt {
+		for _, responses := range c.entries {
+			for key, resp := range responses {
+				if !now.Before(resp.expires) {
+					delete(responses, key)
+					n--
+				}
+			}
+		}
+		c.sweepAt = 2 * n
+		if c.sweepAt < minCacheSweep {
+			c.sweepAt = minCacheSweep
+		}
+	}
+}
+
+// len returns the number of cached responses. c.mu must be held.
+func (c *responseCache) len() int {
+	n := 0
+	for _, responses := range c.entries {
+		n += len(responses)
+	}
+	return n
+}
+
+// invalidate drops the cached responses of apis.
+func (c *responseCache) invalidate(apis ...*api) {
+	if c == nil || len(apis) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, a := range apis {
+		delete(c.entries, a)
+	}
+	c.generation++
+}
+
+// invalidateAll drops all cached responses.
+func (c *responseCache) invalidateAll() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[*api]map[string]cachedResponse)
+	c.generation++
+}
+
+func (c *responseCache) stats() cacheStats {
+	if c == nil {
+		return cacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return cacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: c.len(),
+	}
+}
+
+// responseCacheKey returns the cache key for an API's arguments.
+// Requests from the same user share responses, whichever device
+// credentials they were authenticated with.
+func responseCacheKey(args interface{}) string {
+	if d, ok := args.(deviceAuthenticated); ok {
+		auth := *d.auth()
+		*d.auth() = deviceAuth{UserID: auth.UserID}
+		defer func() { *d.auth() = auth }()
+	}
+	return fmt.Sprintf("%+v", args)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := newResponseCache(time.Hour)
+
+	loads := 0
+	load := func(body string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			loads++
+			return []byte(body), nil
+		}
+	}
+	get := func(a *api, key string, body string) string {
+		t.Helper()
+		got, err := c.get(context.Background(), a, key, load(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(got)
+	}
+
+	assert.Equal(t, "events 1", get(&apiEventList, "1", "events 1"))
+	assert.Equal(t, "events 1", get(&apiEventList, "1", "other"))
+	assert.Equal(t, "events 2", get(&apiEventList, "2", "events 2"))
+	assert.Equal(t, "info", get(&apiInfoList, "", "info"))
+	assert.Equal(t, 3, loads)
+	assert.Equal(t, cacheStats{Hits: 1, Misses: 3, Entries: 3}, c.stats())
+
+	// Invalidating an API only drops its own responses.
+	c.invalidate(&apiEventList)
+	assert.Equal(t, "new events 1", get(&apiEventList, "1", "new events 1"))
+	assert.Equal(t, "info", get(&apiInfoList, "", "other"))
+	assert.Equal(t, 4, loads)
+
+	c.invalidateAll()
+	assert.Equal(t, "new info", get(&apiInfoList, "", "new info"))
+	assert.Equal(t, cacheStats{Hits: 2, Misses: 5, Entries: 1}, c.stats())
+
+	// Failed loads aren't cached.
+	_, err := c.get(context.Background(), &apiConferenceList, "", func() ([]byte, error) { return nil, errors.New("oops") })
+	assert.EqualError(t, err, "oops")
+	assert.Equal(t, "conferences", get(&apiConferenceList, "", "conferences"))
+
+	// Expired responses are loaded again.
+	c.ttl = 0
+	c.invalidateAll()
+	loads = 0
+	get(&apiInfoList, "", "info")
+	get(&apiInfoList, "", "info")
+	assert.Equal(t, 2, loads)
+}
+
+func TestResponseCacheInvalidatedDuringLoad(t *testing.T) {
+	c := newResponseCache(time.Hour)
+
+	// A response loaded before an invalidation isn't cached.
+	body, err := c.get(context.Background(), &apiInfoList, "", func() ([]byte, error) {
+		c.invalidateAll()
+		return []byte("stale"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "stale", string(body))
+	assert.Equal(t, 0, c.stats().Entries)
+}
+
+func TestResponseCacheSingleflight(t *testing.T) {
+	c := newResponseCache(time.Hour)
+
+	const n = 10
+	release := make(chan struct{})
+	var mu sync.Mutex
+	loads := 0
+	load := func() ([]byte, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		<-release
+		return []byte("info"), nil
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, err := c.get(context.Background(), &apiInfoList, "", load)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies[i] = string(body)
+		}(i)
+	}
+	// Wait for every request to miss the cache before the query
+	// finishes.
+	for c.stats().Misses < n {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, 1, loads)
+	for _, body := range bodies {
+		assert.Equal(t, "info", body)
+	}
+}
+
+func TestResponseCacheCanceledRequest(t *testing.T) {
+	c := newResponseCache(time.Hour)
+
+	// The request that starts a load gives up on it, but the load
+	// carries on for the request waiting on it.
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := c.get(ctx, &apiInfoList, "", func() ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("info"), nil
+	})
+	assert.Equal(t, context.Canceled, err)
+
+	done := make(chan string)
+	go func() {
+		body, err := c.get(context.Background(), &apiInfoList, "", func() ([]byte, error) {
+			t.Error("the load in flight should be shared")
+			return nil, nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- string(body)
+	}()
+	for c.stats().Misses < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	assert.Equal(t, "info", <-done)
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	args := &eventListArgs{conferenceArgs: conferenceArgs{ConferenceID: 1}}
+	args.DeviceToken = "token"
+	args.UserID = 7
+	other := &eventListArgs{conferenceArgs: conferenceArgs{ConferenceID: 1}}
+	other.DeviceID = "device"
+	other.UserID = 7
+
+	assert.Equal(t, responseCacheKey(args), responseCacheKey(other))
+	assert.Equal(t, "token", args.DeviceToken)
+
+	other.UserID = 8
+	assert.NotEqual(t, responseCacheKey(args), responseCacheKey(other))
+	other.UserID = 7
+	other.ConferenceID = 2
+	assert.NotEqual(t, responseCacheKey(args), responseCacheKey(other))
+}
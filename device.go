@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dxe/alc-mobile-api/model"
+)
+
+// Device tokens authenticate requests to the public API on behalf of a
+// user. /api/user/add issues one when a device registers, and it has
+// the form "<user id>.<signature>", where the signature is an
+// HMAC-SHA256 of the user ID and the user's device secret keyed with
+// DEVICE_TOKEN_SECRET. The device secret is random and replaced each
+// time the device registers, which revokes the tokens issued before.
+
+var (
+	errNoDeviceToken      = errors.New("missing device token")
+	errInvalidDeviceToken = errors.New("invalid device token")
+)
+
+// signDeviceToken returns the device token for userID with the user's
+// device secret.
+func signDeviceToken(key []byte, userID int, secret string) (string, error) {
+	if len(key) == 0 {
+		return "", errors.New("device token key is not configured")
+	}
+	if secret == "" {
+		return "", errors.New("user has no device secret")
+	}
+	id := strconv.Itoa(userID)
+	return id + "." + base64.RawURLEncoding.EncodeToString(deviceTokenMAC(key, id, secret)), nil
+}
+
+// verifyDeviceToken checks token's signature against the device secret
+// that getSecret returns for the user, and returns the user ID it was
+// issued for. It returns errInvalidDeviceToken if the token wasn't
+// issued by us, or has since been replaced.
+func verifyDeviceToken(key []byte, token string, getSecret func(userID int) (string, error)) (int, error) {
+	if len(key) == 0 {
+		return 0, errors.New("device token key is not configured")
+	}
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return 0, errInvalidDeviceToken
+	}
+	id, encodedSig := token[:i], token[i+1:]
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, errInvalidDeviceToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return 0, errInvalidDeviceToken
+	}
+	secret, err := getSecret(userID)
+	if err == sql.ErrNoRows || (err == nil && secret == "") {
+		return 0, errInvalidDeviceToken
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get device secret: %w", err)
+	}
+	if !hmac.Equal(sig, deviceTokenMAC(key, id, secret)) {
+		return 0, errInvalidDeviceToken
+	}
+	return userID, nil
+}
+
+func deviceTokenMAC(key []byte, id, secret string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("alc-mobile-api device " + id + "." + secret))
+	return mac.Sum(nil)
+}
+
+// verifyDeviceToken checks token against the device secrets in s.db.
+func (s *server) verifyDeviceToken(token string) (int, error) {
+	return verifyDeviceToken(s.deviceTokenKey, token, func(userID int) (string, error) {
+		return model.GetDeviceSecret(s.r.Context(), s.db, userID)
+	})
+}
+
+// deviceAuth is embedded in the arguments of APIs that act on behalf
+// of a device's user. After decoding the request, api.serve checks
+// DeviceToken and fills in UserID for the query to use as :user_id.
+type deviceAuth struct {
+	DeviceToken string `json:"device_token" db:"-"`
+	UserID      int    `json:"-" db:"user_id"`
+
+	// DeviceID identifies the user of apps released before device
+	// tokens, while legacy device IDs are allowed.
+	DeviceID string `json:"device_id" db:"device_id"`
+}
+
+func (d *deviceAuth) auth() *deviceAuth { return d }
+
+// deviceAuthenticated is implemented by API arguments that embed
+// deviceAuth.
+type deviceAuthenticated interface {
+	auth() *deviceAuth
+}
+
+// authenticateDevice sets d.UserID to the user identified by the
+// request's device token. If the request has no token, the user is
+// instead looked up by device ID when s allows legacy device IDs,
+// and the device hasn't been issued a token that it has used. If that
+// fails too, it returns errNoDeviceToken.
+func (s *server) authenticateDevice(d *deviceAuth) error {
+	if d.DeviceToken != "" {
+		userID, err := s.verifyDeviceToken(d.DeviceToken)
+		if err != nil {
+			return err
+		}
+		if err := model.MarkDeviceTokenUsed(s.r.Context(), s.db, userID); err != nil {
+			return fmt.Errorf("failed to record device token use: %w", err)
+		}
+		d.UserID = userID
+		return nil
+	}
+
+	if !s.legacyDeviceIDs || d.DeviceID == "" {
+		return errNoDeviceToken
+	}
+	userID, err := model.GetLegacyDeviceUserID(s.r.Context(), s.db, d.DeviceID)
+	if err == sql.ErrNoRows {
+		return errNoDeviceToken
+	}
+	if err != nil {
+		return err
+	}
+	d.UserID = userID
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	expo "github.com/jakehobbs/exponent-server-sdk-golang/sdk"
+)
+
+// fakeExpo is an in-process stand-in for the Expo push service. It
+// implements PushSender and ReceiptFetcher, records every message it
+// is asked to send, and can be scripted to return errors for specific
+// push tokens.
+type fakeExpo struct {
+	mu sync.Mutex
+
+	// publishErr, if set, is returned from every publish call.
+	publishErr error
+	// ticketErrors maps a push token to the error code returned in its
+	// push ticket (e.g., expo.ErrorDeviceNotRegistered).
+	ticketErrors map[string]string
+	// receiptErrors maps a push token to the error code returned in
+	// its push receipt.
+	receiptErrors map[string]string
+
+	messages []expo.PushMessage
+	tickets  map[string]string // ticket ID -> push token
+}
+
+func newFakeExpo() *fakeExpo {
+	return &fakeExpo{
+		ticketErrors:  make(map[string]string),
+		receiptErrors: make(map[string]string),
+		tickets:       make(map[string]string),
+	}
+}
+
+func (f *fakeExpo) PublishMultipleWithContext(ctx context.Context, messages []expo.PushMessage) ([]expo.PushResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.publishErr != nil {
+		return nil, f.publishErr
+	}
+
+	responses := make([]expo.PushResponse, len(messages))
+	for i, m := range messages {
+		f.messages = append(f.messages, m)
+		token := string(m.To[0])
+		responses[i].PushMessage = m
+		if code, ok := f.ticketErrors[token]; ok {
+			responses[i].Status = "error"
+			responses[i].Message = code
+			responses[i].Details = map[string]string{"error": code}
+			continue
+		}
+		id := "ticket-" + strconv.Itoa(len(f.tickets)+1)
+		f.tickets[id] = token
+		responses[i].Status = expo.SuccessStatus
+		responses[i].ID = id
+	}
+	return responses, nil
+}
+
+func (f *fakeExpo) GetReceipts(ctx context.Context, ids []string) (map[string]ExpoReceipt, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	receipts := make(map[string]ExpoReceipt)
+	for _, id := range ids {
+		token, ok := f.tickets[id]
+		if !ok {
+			continue
+		}
+		if code, ok := f.receiptErrors[token]; ok {
+			receipts[id] = ExpoReceipt{Status: "error", Message: code, Details: map[string]string{"error": code}}
+			continue
+		}
+		receipts[id] = ExpoReceipt{Status: expo.SuccessStatus}
+	}
+	return receipts, nil
+}
+
+// sent returns the messages sent so far.
+func (f *fakeExpo) sent() []expo.PushMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]expo.PushMessage(nil), f.messages...)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/dxe/alc-mobile-api/model"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	fcmDefaultHost = "https://fcm.googleapis.com"
+	fcmScope       = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+// FCMClient sends notifications to Android devices through the
+// Firebase Cloud Messaging HTTP v1 API.
+type FCMClient struct {
+	host       string
+	projectID  string
+	httpClient *http.Client
+}
+
+// NewFCMClient returns an FCMClient that authenticates using the
+// given Google service account key (the JSON file downloaded from the
+// Firebase console).
+func NewFCMClient(serviceAccountJSON []byte) (*FCMClient, error) {
+	var key struct {
+		ProjectID    string `json:"project_id"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(serviceAccountJSON, &key); err != nil {
+		return nil, fmt.Errorf("failed to parse FCM service account key: %w", err)
+	}
+	if key.ProjectID == "" || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("FCM service account key is missing project_id, client_email, or private_key")
+	}
+
+	conf := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		TokenURL:     key.TokenURI,
+		Scopes:       []string{fcmScope},
+	}
+	if conf.TokenURL == "" {
+		conf.TokenURL = "https://oauth2.googleapis.com/token"
+	}
+
+	return &FCMClient{
+		host:       fcmDefaultHost,
+		projectID:  key.ProjectID,
+		httpClient: conf.Client(context.Background()),
+	}, nil
+}
+
+func (c *FCMClient) Send(ctx context.Context, n model.Notification) error {
+	type notification struct {
+		Title string `json:"title,omitempty"`
+		Body  string `json:"body"`
+	}
+	type androidNotification struct {
+		ChannelID         string `json:"channel_id,omitempty"`
+		Sound             string `json:"sound,omitempty"`
+		NotificationCount int64  `json:"notification_count,omitempty"`
+	}
+	type androidConfig struct {
+		Priority     string              `json:"priority,omitempty"`
+		TTL          string              `json:"ttl,omitempty"`
+		Notification androidNotification `json:"notification"`
+	}
+	type message struct {
+		Token        string            `json:"token"`
+		Notification notification      `json:"notification"`
+		Data         map[string]string `json:"data,omitempty"`
+		Android      androidConfig     `json:"android"`
+	}
+	m := message{
+		Token:        n.ExpoPushToken,
+		Notification: notification{Title: n.Title, Body: n.Body},
+		Data:         notificationData(n),
+		Android: androidConfig{
+			Priority: fcmPriority(n.Priority),
+			Notification: androidNotification{
+				ChannelID:         n.ChannelID,
+				Sound:             n.Sound,
+				NotificationCount: n.Badge.Int64,
+			},
+		},
+	}
+	if n.TTLSeconds > 0 {
+		m.Android.TTL = strconv.Itoa(n.TTLSeconds) + "s"
+	}
+	body, err := json.Marshal(map[string]message{"message": m})
+	if err != nil {
+		return err
+	}
+
+	url := c.host + "/v1/projects/" + c.projectID + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return fcmError(resp)
+}
+
+// fcmPriority maps an Expo-style priority onto an Android message
+// priority. As with Expo, "default" is delivered as high priority.
+func fcmPriority(priority string) string {
+	if priority == "normal" {
+		return "NORMAL"
+	}
+	return "HIGH"
+}
+
+// fcmError converts an FCM error response into an error suitable for
+// returning from Send.
+//
+// See https://firebase.google.com/docs/reference/fcm/rest/v1/ErrorCode.
+func fcmError(resp *http.Response) error {
+	var r struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&r)
+
+	code := r.Error.Status
+	for _, d := range r.Error.Details {
+		if d.ErrorCode != "" {
+			code = d.ErrorCode
+		}
+	}
+	msg := fmt.Sprintf("fcm: %d %s: %s", resp.StatusCode, code, r.Error.Message)
+
+	switch {
+	case code == "UNREGISTERED" || resp.StatusCode == http.StatusNotFound:
+		return ErrUnregistered
+	case code == "INVALID_ARGUMENT" || code == "SENDER_ID_MISMATCH":
+		return &PermanentPushError{Reason: msg}
+	}
+	// QUOTA_EXCEEDED, UNAVAILABLE, INTERNAL, and authentication
+	// problems may all resolve themselves, so retry.
+	return errors.New(msg)
+}
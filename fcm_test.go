@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dxe/alc-mobile-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeFCM starts a local stand-in for the FCM HTTP v1 API. Tokens
+// found in failures get the corresponding error response.
+func newFakeFCM(t *testing.T, failures map[string]string) (*httptest.Server, *[]string) {
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/projects/test-project/messages:send" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			Message struct {
+				Token        string `json:"token"`
+				Notification struct {
+					Title string `json:"title"`
+					Body  string `json:"body"`
+				} `json:"notification"`
+			} `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tokens = append(tokens, req.Message.Token)
+
+		var status int
+		switch failures[req.Message.Token] {
+		case "":
+			json.NewEncoder(w).Encode(map[string]string{"name": "projects/test-project/messages/1"})
+			return
+		case "UNREGISTERED":
+			status = http.StatusNotFound
+		case "INVALID_ARGUMENT":
+			status = http.StatusBadRequest
+		default:
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]interface{}{
+				"code":    status,
+				"message": "error",
+				"status":  failures[req.Message.Token],
+				"details": []map[string]string{{"errorCode": failures[req.Message.Token]}},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &tokens
+}
+
+func TestFCMClientSend(t *testing.T) {
+	srv, tokens := newFakeFCM(t, map[string]string{
+		"gone":  "UNREGISTERED",
+		"bad":   "INVALID_ARGUMENT",
+		"flaky": "UNAVAILABLE",
+	})
+	client := &FCMClient{host: srv.URL, projectID: "test-project", httpClient: srv.Client()}
+	ctx := context.Background()
+
+	send := func(token string) error {
+		return client.Send(ctx, model.Notification{ExpoPushToken: token, Title: "Title", Body: "Body"})
+	}
+
+	assert.NoError(t, send("ok"))
+	assert.ErrorIs(t, send("gone"), ErrUnregistered)
+
+	var permanent *PermanentPushError
+	assert.ErrorAs(t, send("bad"), &permanent)
+
+	err := send("flaky")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnregistered)
+	assert.False(t, errors.As(err, &permanent))
+
+	assert.Equal(t, []string{"ok", "gone", "bad", "flaky"}, *tokens)
+}
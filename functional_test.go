@@ -29,6 +29,7 @@ func TestServer(t *testing.T) {
 	os.Setenv("S3_REGION", "testVal")
 	os.Setenv("S3_AUTH_ID", "testVal")
 	os.Setenv("S3_SECRET", "testVal")
+	os.Setenv("DEVICE_TOKEN_SECRET", "testVal")
 	go main0(db)
 
 
@@ -15,5 +15,7 @@ require (
 	github.com/pquerna/cachecontrol v0.1.0 // indirect
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/oauth2 v0.0.0-20210628180205-a41e5a781914
+	golang.org/x/sync v0.0.0-20220907140024-f12130a52804
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
 	gopkg.in/square/go-jose.v2 v2.6.0 // indirect
 )
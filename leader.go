@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// leaderCheckInterval is how often a Leader tries to take the lock, or
+// checks that it still holds it.
+const leaderCheckInterval = 10 * time.Second
+
+// Leader uses a MySQL named lock to elect one process among several
+// replicas to run work that shouldn't run concurrently.
+//
+// MySQL releases a named lock when the connection holding it closes, so
+// if the leader dies or loses its connection, another replica takes
+// over within leaderCheckInterval. Work guarded by a Leader should
+// still be safe to repeat, since the old leader may not notice it has
+// lost the lock until its next check.
+type Leader struct {
+	db   *sqlx.DB
+	name string
+
+	mu     sync.Mutex
+	conn   *sql.Conn
+	leader bool
+}
+
+// NewLeader returns a Leader for the named lock. Call Run to start
+// campaigning.
+func NewLeader(db *sqlx.DB, name string) *Leader {
+	return &Leader{db: db, name: name}
+}
+
+// IsLeader reports whether this process currently holds the lock.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// Run campaigns for the lock and keeps checking that it is still held.
+// It returns, releasing the lock, once ctx is canceled.
+func (l *Leader) Run(ctx context.Context) {
+	for {
+		l.check(ctx)
+		select {
+		case <-ctx.Done():
+			l.release()
+			return
+		case <-time.After(leaderCheckInterval):
+		}
+	}
+}
+
+func (l *Leader) check(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		// Make sure the connection holding the lock is still alive.
+		var held sql.NullBool
+		err := l.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", l.name).Scan(&held)
+		if err == nil && held.Bool {
+			return
+		}
+		log.Printf("Lost leadership of %q: %v\n", l.name, err)
+		l.conn.Close()
+		l.conn = nil
+		l.leader = false
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		log.Printf("Failed to get connection for leader election: %v\n", err)
+		return
+	}
+	var acquired sql.NullBool
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", l.name).Scan(&acquired); err != nil || !acquired.Bool {
+		if err != nil {
+			log.Printf("Failed to acquire lock %q: %v\n", l.name, err)
+		}
+		conn.Close()
+		return
+	}
+	log.Printf("Became leader of %q.\n", l.name)
+	l.conn = conn
+	l.leader = true
+}
+
+func (l *Leader) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+	if _, err := l.conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", l.name); err != nil {
+		log.Printf("Failed to release lock %q: %v\n", l.name, err)
+	}
+	l.conn.Close()
+	l.conn = nil
+	l.leader = false
+}
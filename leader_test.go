@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderElection(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	a := NewLeader(db, "test-leader")
+	b := NewLeader(db, "test-leader")
+
+	a.check(ctx)
+	b.check(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// Re-checking keeps the lock with the current leader.
+	a.check(ctx)
+	b.check(ctx)
+	assert.True(t, a.IsLeader())
+	assert.False(t, b.IsLeader())
+
+	// Once the leader releases the lock, the other replica takes over.
+	a.release()
+	assert.False(t, a.IsLeader())
+	b.check(ctx)
+	assert.True(t, b.IsLeader())
+	b.release()
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/coreos/go-oidc"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	expo "github.com/jakehobbs/exponent-server-sdk-golang/sdk"
@@ -22,7 +27,8 @@ import (
 )
 
 var (
-	flagProd = flag.Bool("prod", false, "whether to run in production mode")
+	flagProd    = flag.Bool("prod", false, "whether to run in production mode")
+	flagWorkers = flag.String("workers", "on", `whether to run the background notification workers: "on" to run them alongside the API, "off" to run only the API, or "only" to run only the workers`)
 )
 
 func config(key string) string {
@@ -33,6 +39,14 @@ func config(key string) string {
 	return v
 }
 
+// configIntDefault is like configInt, but returns def if key isn't set.
+func configIntDefault(key string, def int) int {
+	if os.Getenv(key) == "" {
+		return def
+	}
+	return configInt(key)
+}
+
 func configInt(key string) int {
 	intVal, err := strconv.Atoi(config(key))
 	if err != nil {
@@ -41,8 +55,13 @@ func configInt(key string) int {
 	return intVal
 }
 
-const isoTimeLayout = "2006-01-02T15:04:05.000Z"
-const dbTimeLayout = "2006-01-02 15:04:05"
+// pickerTimeLayout is the format of the admin site's date pickers.
+// Times are picked in the time zone of the conference they belong to,
+// so the layout has no zone of its own.
+const pickerTimeLayout = "2006-01-02 15:04"
+
+// displayTimeLayout is how times are shown in the admin site.
+const displayTimeLayout = "Mon, Jan 2, 2006 at 3:04 PM MST"
 
 // getDSN returns the DSN string for the backing MySQL database.
 func getDSN() string {
@@ -66,12 +85,82 @@ func getDSN() string {
 func main() {
 	flag.Parse()
 	db := model.NewDB(getDSN())
+	if flag.Arg(0) == "migrate" {
+		migrate(db, flag.Args()[1:])
+		return
+	}
+	model.InitDatabase(db)
 	main0(db)
 }
 
+// migrate runs the migrate subcommand, which manages the database
+// schema without starting the server:
+//
+//	alc-mobile-api migrate [up]          applies pending migrations
+//	alc-mobile-api migrate down [steps]  reverts the last steps migrations (default 1)
+//	alc-mobile-api migrate status        lists the migrations and whether they're applied
+func migrate(db *sqlx.DB, args []string) {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		if err := model.Migrate(db); err != nil {
+			log.Fatal(err)
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatalf("invalid number of migrations to revert %q", args[1])
+			}
+		}
+		if err := model.MigrateDown(db, steps); err != nil {
+			log.Fatal(err)
+		}
+	case "status":
+		status, err := model.GetMigrationStatus(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.Applied {
+				applied = "applied"
+			}
+			fmt.Printf("%04d_%v\t%v\n", m.Version, m.Name, applied)
+		}
+	default:
+		log.Fatalf("unknown migrate command %q", command)
+	}
+}
+
 func main0(db *sqlx.DB) {
 	flag.Parse()
 
+	// Deploys stop the old tasks with SIGTERM.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch *flagWorkers {
+	case "on", "off":
+	case "only":
+		workers := startWorkers(ctx, db)
+		// Worker processes don't serve the API, but still answer
+		// health checks.
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+			(&server{w: w, r: r}).health()
+		})
+		log.Println("Workers started. Listening on port 8080.")
+		serve(ctx, mux, workers)
+		return
+	default:
+		log.Fatalf("invalid -workers value %q", *flagWorkers)
+	}
+
 	// TODO(mdempsky): Generalize.
 	mux := http.NewServeMux()
 
@@ -100,14 +189,29 @@ func main0(db *sqlx.DB) {
 		log.Fatalf("failed to create AWS session: %v", err)
 	}
 
-	expoPushClient := expo.NewPushClient(&expo.ClientConfig{AccessToken: os.Getenv("EXPO_PUSH_ACCESS_TOKEN")})
+	// Admin access is granted from the admin site, so somebody needs
+	// to own it to begin with.
+	if email := os.Getenv("ADMIN_OWNER_EMAIL"); email != "" {
+		if err := model.EnsureOwner(db, email); err != nil {
+			log.Fatalf("failed to add owner %v: %v", email, err)
+		}
+	}
+
+	deviceTokenKey := []byte(config("DEVICE_TOKEN_SECRET"))
+	// Apps released before device tokens identify themselves by device
+	// ID alone. Keep accepting that until they have all been updated.
+	legacyDeviceIDs := os.Getenv("ALLOW_LEGACY_DEVICE_IDS") == "true"
+
+	cache := newResponseCache(apiCacheTTL)
 
 	newServer := func(w http.ResponseWriter, r *http.Request) *server {
 		return &server{
-			conf:           conf,
-			verifier:       verifier,
-			awsSession:     awsSession,
-			expoPushClient: expoPushClient,
+			conf:            conf,
+			verifier:        verifier,
+			awsSession:      awsSession,
+			deviceTokenKey:  deviceTokenKey,
+			legacyDeviceIDs: legacyDeviceIDs,
+			cache:           cache,
 
 			db: db,
 			w:  w,
@@ -124,10 +228,11 @@ func main0(db *sqlx.DB) {
 	}
 
 	// handleAuth is like handle, but it requires the user to be logged
-	// in with OAuth2 credentials first. Currently, this means with an
-	// @directactioneverywhere.com account, because our OAuth2 settings
-	// are configured to "Internal".
-	handleAuth := func(path string, method func(*server)) {
+	// in with OAuth2 credentials first, and to have at least role for
+	// some conference. Handlers that act on a particular conference's
+	// data also check the role for that conference with
+	// server.authorize.
+	handleAuth := func(path, role string, method func(*server)) {
 		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
 			s := newServer(w, r)
 
@@ -143,46 +248,100 @@ func main0(db *sqlx.DB) {
 			}
 			s.email = email
 
+			s.role, err = model.GetHighestAdminRole(s.db, email)
+			if err != nil {
+				s.adminError(err)
+				return
+			}
+			if !model.RoleAtLeast(s.role, role) {
+				s.adminError(fmt.Errorf("%w: %v doesn't have %v access", errForbidden, email, role))
+				return
+			}
+
+			if err := s.loadCSRFToken(); err != nil {
+				s.adminError(err)
+				return
+			}
+
+			method(s)
+		})
+	}
+
+	// handleAuthPost is like handleAuth, but for handlers that change
+	// data. It only allows POST requests that carry the session's CSRF
+	// token, and drops the cached API responses afterwards, as the
+	// change may show up in any of them.
+	handleAuthPost := func(path, role string, method func(*server)) {
+		handleAuth(path, role, func(s *server) {
+			if s.r.Method != http.MethodPost {
+				s.w.Header().Set("Allow", http.MethodPost)
+				http.Error(s.w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if err := s.checkCSRFToken(); err != nil {
+				s.adminError(err)
+				return
+			}
 			method(s)
+			s.cache.invalidateAll()
 		})
 	}
 
 	// Index & auth pages
-	handleAuth("/", (*server).index)
+	handleAuth("/", model.RoleViewer, (*server).index)
 	handle("/login", (*server).login)
 	handle("/logout", (*server).logout)
 	handle("/auth", (*server).auth)
-	handleAuth("/admin", (*server).admin)
+	handleAuth("/admin", model.RoleViewer, (*server).admin)
 
 	// Admin conference pages
-	handleAuth("/admin/conferences", (*server).adminConferences)
-	handleAuth("/admin/conference/details", (*server).adminConferenceDetails)
-	handleAuth("/admin/conference/save", (*server).adminConferenceSave)
-	handleAuth("/admin/conference/delete", (*server).adminConferenceDelete)
+	handleAuth("/admin/conferences", model.RoleViewer, (*server).adminConferences)
+	handleAuth("/admin/conference/details", model.RoleViewer, (*server).adminConferenceDetails)
+	handleAuthPost("/admin/conference/save", model.RoleEditor, (*server).adminConferenceSave)
+	handleAuthPost("/admin/conference/delete", model.RoleEditor, (*server).adminConferenceDelete)
 
 	// Admin location pages
-	handleAuth("/admin/locations", (*server).adminLocations)
-	handleAuth("/admin/location/details", (*server).adminLocationDetails)
-	handleAuth("/admin/location/save", (*server).adminLocationSave)
-	handleAuth("/admin/location/delete", (*server).adminLocationDelete)
+	handleAuth("/admin/locations", model.RoleViewer, (*server).adminLocations)
+	handleAuth("/admin/location/details", model.RoleViewer, (*server).adminLocationDetails)
+	handleAuthPost("/admin/location/save", model.RoleEditor, (*server).adminLocationSave)
+	handleAuthPost("/admin/location/delete", model.RoleEditor, (*server).adminLocationDelete)
 
 	// Admin event pages
-	handleAuth("/admin/events", (*server).adminEvents)
-	handleAuth("/admin/event/details", (*server).adminEventDetails)
-	handleAuth("/admin/event/save", (*server).adminEventSave)
-	handleAuth("/admin/event/delete", (*server).adminEventDelete)
+	handleAuth("/admin/events", model.RoleViewer, (*server).adminEvents)
+	handleAuth("/admin/event/details", model.RoleViewer, (*server).adminEventDetails)
+	handleAuthPost("/admin/event/save", model.RoleEditor, (*server).adminEventSave)
+	handleAuthPost("/admin/event/delete", model.RoleEditor, (*server).adminEventDelete)
 
 	// Admin info pages
-	handleAuth("/admin/info", (*server).adminInfo)
-	handleAuth("/admin/info/details", (*server).adminInfoDetails)
-	handleAuth("/admin/info/save", (*server).adminInfoSave)
-	handleAuth("/admin/info/delete", (*server).adminInfoDelete)
+	handleAuth("/admin/info", model.RoleViewer, (*server).adminInfo)
+	handleAuth("/admin/info/details", model.RoleViewer, (*server).adminInfoDetails)
+	handleAuthPost("/admin/info/save", model.RoleEditor, (*server).adminInfoSave)
+	handleAuthPost("/admin/info/delete", model.RoleEditor, (*server).adminInfoDelete)
 
 	// Admin announcement pages
-	handleAuth("/admin/announcements", (*server).adminAnnouncements)
-	handleAuth("/admin/announcement/details", (*server).adminAnnouncementDetails)
-	handleAuth("/admin/announcement/save", (*server).adminAnnouncementSave)
-	handleAuth("/admin/announcement/delete", (*server).adminAnnouncementDelete)
+	handleAuth("/admin/announcements", model.RoleViewer, (*server).adminAnnouncements)
+	handleAuth("/admin/announcements/review", model.RoleViewer, (*server).adminAnnouncementReview)
+	handleAuthPost("/admin/announcement/approve", model.RoleAnnouncer, (*server).adminAnnouncementApprove)
+	handleAuthPost("/admin/announcement/revision/approve", model.RoleAnnouncer, (*server).adminAnnouncementRevisionApprove)
+	handleAuth("/admin/announcement/details", model.RoleViewer, (*server).adminAnnouncementDetails)
+	handleAuthPost("/admin/announcement/save", model.RoleAnnouncer, (*server).adminAnnouncementSave)
+	handleAuthPost("/admin/announcement/delete", model.RoleAnnouncer, (*server).adminAnnouncementDelete)
+	handleAuthPost("/admin/announcement/requeue", model.RoleAnnouncer, (*server).adminAnnouncementRequeue)
+	handleAuthPost("/admin/announcement/retract", model.RoleAnnouncer, (*server).adminAnnouncementRetract)
+	handleAuth("/admin/announcement/recipients", model.RoleViewer, (*server).adminAnnouncementRecipients)
+	handleAuth("/admin/announcement/progress", model.RoleViewer, (*server).adminAnnouncementProgress)
+	handleAuth("/admin/announcement/delivery", model.RoleViewer, (*server).adminAnnouncementDelivery)
+
+	// Admin access pages
+	handleAuth("/admin/admins", model.RoleOwner, (*server).adminAdmins)
+	handleAuthPost("/admin/admin/save", model.RoleOwner, (*server).adminAdminSave)
+	handleAuthPost("/admin/admin/delete", model.RoleOwner, (*server).adminAdminDelete)
+	handleAuth("/admin/audit", model.RoleViewer, (*server).adminAudit)
+
+	// Admin trash pages
+	handleAuth("/admin/trash", model.RoleViewer, (*server).adminTrash)
+	handleAuthPost("/admin/trash/restore", model.RoleAnnouncer, (*server).adminTrashRestore)
+	handleAuthPost("/admin/trash/purge", model.RoleOwner, (*server).adminTrashPurge)
 
 	// Healthcheck for load balancer
 	handle("/healthcheck", (*server).health)
@@ -193,28 +352,126 @@ func main0(db *sqlx.DB) {
 	handle("/api/event/list", apiEventList.serve)
 	handle("/api/event/rsvp", apiEventRSVP.serve)
 	handle("/api/info/list", apiInfoList.serve)
-	handle("/api/user/add", apiUserAdd.serve)
+	handle("/api/user/add", apiUserAdd)
 	handle("/api/user/register_push_notifications", apiUserRegisterPushNotifications.serve)
+	handle("/api/user/event_reminders", apiUserEventReminders.serve)
+	handle("/api/user/notification_preferences", apiUserNotificationPreferences.serve)
+	handle("/api/user/notification_preferences/update", apiUserUpdateNotificationPreferences.serve)
 
 	// Static file server
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 
-	// Start go routines for queueing and sending notifications.
-	go EnqueueAnnouncementNotificationsWrapper(db)
-	go SendNotificationsWrapper(db, expoPushClient)
+	workers := new(sync.WaitGroup)
+	if *flagWorkers == "on" {
+		workers = startWorkers(ctx, db)
+	}
 
 	log.Println("Server started. Listening on port 8080.")
-	server := &http.Server{Addr: ":8080", Handler: mux}
-	log.Fatal(server.ListenAndServe())
+	serve(ctx, mux, workers)
 }
 
-type server struct {
-	conf           *oauth2.Config
-	verifier       *oidc.IDTokenVerifier
-	awsSession     *session.Session
-	expoPushClient *expo.PushClient
+// shutdownTimeout is how long to wait for in-flight requests to finish
+// once we're asked to stop. ECS kills the task 30 seconds after
+// sending SIGTERM.
+const shutdownTimeout = 20 * time.Second
+
+// serve serves HTTP requests on port 8080 until ctx is canceled. It
+// then stops accepting requests, waits for current ones to finish,
+// and waits for workers to release their notifications.
+func serve(ctx context.Context, handler http.Handler, workers *sync.WaitGroup) {
+	server := &http.Server{Addr: ":8080", Handler: handler}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	email string
+	<-ctx.Done()
+	log.Println("Shutting down.")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to finish in-flight requests: %v\n", err)
+	}
+	workers.Wait()
+	log.Println("Shutdown complete.")
+}
+
+// startWorkers starts the goroutines for queueing, sending, and
+// checking notifications. They run in every worker process, but only
+// the elected leader does any work, so that notifications are queued
+// once and sent within one rate limit.
+//
+// The workers stop when ctx is canceled. The returned WaitGroup is
+// done once they have all finished, with any leased notifications
+// released back to the queue.
+func startWorkers(ctx context.Context, db *sqlx.DB) *sync.WaitGroup {
+	expoPushClient := expo.NewPushClient(&expo.ClientConfig{AccessToken: os.Getenv("EXPO_PUSH_ACCESS_TOKEN")})
+	receiptClient := NewReceiptClient(os.Getenv("EXPO_PUSH_ACCESS_TOKEN"))
+
+	pushers := Pushers{Expo: expoPushClient}
+	if fcmCredentials := os.Getenv("FCM_CREDENTIALS"); fcmCredentials != "" {
+		fcmClient, err := NewFCMClient([]byte(fcmCredentials))
+		if err != nil {
+			log.Fatalf("failed to create FCM client: %v", err)
+		}
+		pushers.FCM = fcmClient
+	}
+	if apnsKey := os.Getenv("APNS_KEY"); apnsKey != "" {
+		apnsClient, err := NewAPNsClient([]byte(apnsKey), config("APNS_KEY_ID"), config("APNS_TEAM_ID"), config("APNS_TOPIC"), *flagProd)
+		if err != nil {
+			log.Fatalf("failed to create APNs client: %v", err)
+		}
+		pushers.APNs = apnsClient
+	}
+
+	senderConfig := SenderConfig{
+		Workers:   configIntDefault("PUSH_WORKERS", 4),
+		RateLimit: configIntDefault("PUSH_RATE_LIMIT", 100),
+	}
+	if err := senderConfig.validate(); err != nil {
+		log.Fatalf("invalid notification sender configuration: %v", err)
+	}
+
+	leader := NewLeader(db, "alc-mobile-api-workers")
+
+	var wg sync.WaitGroup
+	start := func(worker func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	start(func() { leader.Run(ctx) })
+	start(func() { EnqueueAnnouncementNotificationsWrapper(ctx, db, leader) })
+	start(func() { EnqueueEventRemindersWrapper(ctx, db, leader) })
+	start(func() { SendNotificationsWrapper(ctx, db, pushers, senderConfig, leader) })
+	start(func() { CheckNotificationReceiptsWrapper(ctx, db, receiptClient, leader) })
+	return &wg
+}
+
+type server struct {
+	conf       *oauth2.Config
+	verifier   *oidc.IDTokenVerifier
+	awsSession *session.Session
+
+	// deviceTokenKey signs the device tokens that authenticate public
+	// API requests. If legacyDeviceIDs is set, requests without a
+	// device token may instead identify the user by device ID.
+	deviceTokenKey  []byte
+	legacyDeviceIDs bool
+
+	// email is the logged in admin's email, and role is their most
+	// privileged role for any conference. csrfToken is their session's
+	// CSRF token, which forms must send back.
+	email     string
+	role      string
+	csrfToken string
+
+	// cache holds the responses of cached public APIs.
+	cache *responseCache
 
 	db *sqlx.DB
 	w  http.ResponseWriter
@@ -230,9 +487,32 @@ func (s *server) index() {
 	s.redirect(absURL("/admin"))
 }
 
+// loadTimeZone returns the named time zone for showing times in, or
+// UTC if there is no such zone. Conferences' time zones are checked
+// when they are saved, so this shouldn't happen.
+func loadTimeZone(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// conferenceTimeZone returns the time zone that the conference's times
+// are entered in.
+func (s *server) conferenceTimeZone(conferenceID int) (*time.Location, error) {
+	conference, err := model.GetConferenceByID(s.db, strconv.Itoa(conferenceID))
+	if err != nil {
+		return nil, err
+	}
+	return conference.TimeZone()
+}
+
 func (s *server) renderTemplate(name string, pageData interface{}) {
 	type templateData struct {
 		UserEmail           string
+		UserRole            string
+		CSRFToken           string
 		PageName            string
 		PageData            interface{}
 		Conferences         []model.Conference
@@ -241,7 +521,7 @@ func (s *server) renderTemplate(name string, pageData interface{}) {
 
 	// TODO: Consider only doing getting this data on pages you need it.
 	// Alternatively, have a Conference selector on the nav bar that is reflected on all pages.
-	conferences, err := model.ListConferences(s.db, model.ConferenceOptions{})
+	conferences, err := model.ListConferences(s.db)
 	if err != nil {
 		log.Println(err)
 		panic("failed to get conferences")
@@ -249,6 +529,8 @@ func (s *server) renderTemplate(name string, pageData interface{}) {
 
 	data := templateData{
 		UserEmail:           s.email,
+		UserRole:            s.role,
+		CSRFToken:           s.csrfToken,
 		PageName:            name,
 		PageData:            pageData,
 		Conferences:         conferences,
@@ -260,6 +542,43 @@ func (s *server) renderTemplate(name string, pageData interface{}) {
 			components := strings.Split(email, "@")
 			return strings.Title(components[0])
 		},
+		// historyTabs returns the data for history_tabs.html.
+		"historyTabs": func(entityType string, id int, active string) map[string]interface{} {
+			return map[string]interface{}{"EntityType": entityType, "ID": id, "Active": active}
+		},
+		// conferenceTimezone returns the time zone of the conference.
+		"conferenceTimezone": func(conferenceID int) string {
+			for _, c := range conferences {
+				if c.ID == conferenceID {
+					return c.Timezone
+				}
+			}
+			return "UTC"
+		},
+		// displayTime formats t in the time zone for people to read.
+		"displayTime": func(t time.Time, timezone string) string {
+			return t.In(loadTimeZone(timezone)).Format(displayTimeLayout)
+		},
+		// pickerTime formats t in the time zone for the date pickers.
+		// The zero time is left blank.
+		"pickerTime": func(t time.Time, timezone string) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.In(loadTimeZone(timezone)).Format(pickerTimeLayout)
+		},
+		// isoTime formats t for the utcDate elements, which show it in
+		// the browser's time zone.
+		"isoTime": func(t time.Time) string {
+			return t.UTC().Format(time.RFC3339)
+		},
+		// auditValue formats a value from an audit log diff.
+		"auditValue": func(v interface{}) string {
+			if v == nil {
+				return "(none)"
+			}
+			return fmt.Sprint(v)
+		},
 	}).ParseGlob("templates/*.html")
 	if err != nil {
 		log.Println(err)
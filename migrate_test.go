@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dxe/alc-mobile-api/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationFiles(t *testing.T) {
+	migrations, err := model.Migrations()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "migrations should be numbered consecutively from 1")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	db := newEmptyTestDB(t)
+	migrations, err := model.Migrations()
+	assert.NoError(t, err)
+
+	tables := func() []string {
+		var tables []string
+		assert.NoError(t, db.Select(&tables, `SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name != 'schema_migrations'`))
+		return tables
+	}
+	applied := func() []bool {
+		status, err := model.GetMigrationStatus(db)
+		assert.NoError(t, err)
+		var applied []bool
+		for _, m := range status {
+			applied = append(applied, m.Applied)
+		}
+		return applied
+	}
+	all := func(applied bool) []bool {
+		status := make([]bool, len(migrations))
+		for i := range status {
+			status[i] = applied
+		}
+		return status
+	}
+
+	// Every migration applies cleanly to an empty database.
+	assert.Equal(t, all(false), applied())
+	assert.NoError(t, model.Migrate(db))
+	assert.Equal(t, all(true), applied())
+	assert.Contains(t, tables(), "notifications")
+
+	// Migrating again does nothing.
+	assert.NoError(t, model.Migrate(db))
+
+	// Every migration can be reverted, leaving the database empty, and
+	// then applied again.
+	assert.NoError(t, model.MigrateDown(db, len(migrations)))
+	assert.Equal(t, all(false), applied())
+	assert.Empty(t, tables())
+	assert.NoError(t, model.Migrate(db))
+	assert.Equal(t, all(true), applied())
+
+	if len(migrations) > 1 {
+		assert.NoError(t, model.MigrateDown(db, 1))
+		assert.Equal(t, append(all(true)[1:], false), applied())
+	}
+}
+
+// TestMigrateBaselineDatabase checks that a database created by
+// InitDatabase before migrations were introduced is migrated to the same
+// schema as a new database, keeping its data.
+func TestMigrateBaselineDatabase(t *testing.T) {
+	baseline, err := ioutil.ReadFile("testdata/baseline_schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := newEmptyTestDB(t)
+	for _, statement := range strings.Split(string(baseline), ";\n") {
+		var lines []string
+		for _, line := range strings.Split(statement, "\n") {
+			if !strings.HasPrefix(line, "--") {
+				lines = append(lines, line)
+			}
+		}
+		if statement := strings.TrimSpace(strings.Join(lines, "\n")); statement != "" {
+			db.MustExec(statement)
+		}
+	}
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	db.MustExec(`INSERT INTO users (id, conference_id, device_id, timestamp, expo_push_token) VALUES (1, 1, 'device', NOW(), 'ExponentPushToken[1]')`)
+	db.MustExec(`
+INSERT INTO announcements (id, conference_id, title, message, long_message, icon, url, url_text, created_by, send_time, sent)
+VALUES (1, 1, 'Sent', 'Message', '', 'bus', '', '', 'a@example.com', '2021-09-24 00:00:00', 1),
+	(2, 1, 'Unsent', 'Message', '', 'bus', '', '', 'a@example.com', '2021-09-25 00:00:00', 0)
+`)
+	db.MustExec(`INSERT INTO notifications (user_id, announcement_id, status) VALUES (1, 1, 'Sent')`)
+
+	assert.NoError(t, model.Migrate(db))
+
+	fresh := newEmptyTestDB(t)
+	assert.NoError(t, model.Migrate(fresh))
+	assert.Equal(t, schema(t, fresh), schema(t, db))
+
+	var states []string
+	assert.NoError(t, db.Select(&states, `SELECT state FROM announcements ORDER BY id`))
+	assert.Equal(t, []string{"approved", "approved"}, states, "announcements from before approvals are approved")
+	var notification struct {
+		ID       int    `db:"id"`
+		UserID   int    `db:"user_id"`
+		Status   string `db:"status"`
+		Attempts int    `db:"attempts"`
+	}
+	assert.NoError(t, db.Get(&notification, `SELECT id, user_id, status, attempts FROM notifications WHERE announcement_id = 1`))
+	assert.NotZero(t, notification.ID)
+	assert.Equal(t, 1, notification.UserID)
+	assert.Equal(t, "Sent", notification.Status)
+	assert.Zero(t, notification.Attempts)
+
+	// The baseline tables are dropped by reverting every migration.
+	migrations, err := model.Migrations()
+	assert.NoError(t, err)
+	assert.NoError(t, model.MigrateDown(db, len(migrations)))
+	var tables []string
+	assert.NoError(t, db.Select(&tables, `SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name != 'schema_migrations'`))
+	assert.Empty(t, tables)
+}
+
+var autoIncrementOption = regexp.MustCompile(` AUTO_INCREMENT=\d+`)
+
+// schema returns the CREATE TABLE statement of each table in db.
+func schema(t *testing.T, db *sqlx.DB) map[string]string {
+	t.Helper()
+	var tables []string
+	if err := db.Select(&tables, `SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()`); err != nil {
+		t.Fatal(err)
+	}
+	schema := make(map[string]string)
+	for _, table := range tables {
+		var name, create string
+		if err := db.QueryRow("SHOW CREATE TABLE "+table).Scan(&name, &create); err != nil {
+			t.Fatal(err)
+		}
+		schema[table] = autoIncrementOption.ReplaceAllString(create, "")
+	}
+	return schema
+}
@@ -0,0 +1,235 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Admin roles, from least to most privileged. Each role may do
+// everything the roles before it may do.
+const (
+	// RoleViewer may view the admin pages.
+	RoleViewer = "viewer"
+	// RoleAnnouncer may also write, approve, and retract announcements.
+	RoleAnnouncer = "announcer"
+	// RoleEditor may also edit conferences, events, locations, and
+	// info.
+	RoleEditor = "editor"
+	// RoleOwner may also invite and remove admins.
+	RoleOwner = "owner"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:    1,
+	RoleAnnouncer: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// ValidRole reports whether role is one of the admin roles.
+func ValidRole(role string) bool {
+	return roleRanks[role] != 0
+}
+
+// RoleAtLeast reports whether role grants everything that min does.
+// The empty role grants nothing.
+func RoleAtLeast(role, min string) bool {
+	return role != "" && roleRanks[role] >= roleRanks[min]
+}
+
+// An Admin grants a Google account a role in the admin site, either
+// for one conference or, if ConferenceID is null, for all of them.
+type Admin struct {
+	ID             int            `db:"id"`
+	Email          string         `db:"email"`
+	ConferenceID   sql.NullInt64  `db:"conference_id"`
+	ConferenceName sql.NullString `db:"conference_name"`
+	Role           string         `db:"role"`
+	InvitedBy      string         `db:"invited_by"`
+	Timestamp      time.Time      `db:"timestamp"`
+}
+
+// ListAdmins lists everybody's roles. If admin is set, only roles for
+// conferences they have a role for are listed.
+func ListAdmins(db *sqlx.DB, admin string) ([]Admin, error) {
+	query := `
+SELECT a.id, a.email, a.conference_id, c.name AS conference_name, a.role, a.invited_by, a.timestamp
+FROM admins a
+LEFT JOIN conferences c ON c.id = a.conference_id
+`
+	var args []interface{}
+	if admin != "" {
+		query += "WHERE " + adminConferenceCondition("a.conference_id") + "\n"
+		args = append(args, normalizeEmail(admin))
+	}
+	query += "ORDER BY a.email, c.name"
+	var admins []Admin
+	if err := db.Select(&admins, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list admins: %w", err)
+	}
+	if admins == nil {
+		admins = make([]Admin, 0)
+	}
+	return admins, nil
+}
+
+func GetAdminByID(db DB, id string) (Admin, error) {
+	const query = `
+SELECT a.id, a.email, a.conference_id, c.name AS conference_name, a.role, a.invited_by, a.timestamp
+FROM admins a
+LEFT JOIN conferences c ON c.id = a.conference_id
+WHERE a.id = ?
+`
+	var admins []Admin
+	if err := db.Select(&admins, query, id); err != nil {
+		return Admin{}, fmt.Errorf("failed to select admin: %w", err)
+	}
+	if len(admins) == 0 {
+		return Admin{}, errors.New("found no admin with given id")
+	}
+	return admins[0], nil
+}
+
+// GetAdminRole returns the most privileged role that email has for
+// the conference, counting roles granted for all conferences. If
+// conferenceID is 0, only roles granted for all conferences count. It
+// returns "" if email has no role.
+func GetAdminRole(db *sqlx.DB, email string, conferenceID int) (string, error) {
+	query := `SELECT role FROM admins WHERE email = ? AND (conference_id IS NULL OR conference_id = ?)`
+	return highestRole(db, query, normalizeEmail(email), conferenceID)
+}
+
+// GetHighestAdminRole returns the most privileged role that email has
+// for any conference, or "" if it has none.
+func GetHighestAdminRole(db *sqlx.DB, email string) (string, error) {
+	return highestRole(db, `SELECT role FROM admins WHERE email = ?`, normalizeEmail(email))
+}
+
+func highestRole(db *sqlx.DB, query string, args ...interface{}) (string, error) {
+	var roles []string
+	if err := db.Select(&roles, query, args...); err != nil {
+		return "", fmt.Errorf("failed to look up admin role: %w", err)
+	}
+	var highest string
+	for _, role := range roles {
+		if roleRanks[role] > roleRanks[highest] {
+			highest = role
+		}
+	}
+	return highest, nil
+}
+
+// GetAdminGrantID returns the ID of the role granted to email for
+// conferenceID, where null means all conferences, or 0 if there is
+// none.
+func GetAdminGrantID(db DB, email string, conferenceID sql.NullInt64) (int, error) {
+	var ids []int
+	if err := db.Select(&ids, `SELECT id FROM admins WHERE email = ? AND conference_id <=> ?`, normalizeEmail(email), conferenceID); err != nil {
+		return 0, fmt.Errorf("failed to look up admin: %w", err)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// SaveAdmin grants admin.Role to admin.Email for admin.ConferenceID,
+// replacing any role it already had there, and returns the grant's ID.
+func SaveAdmin(db DB, admin Admin) (int, error) {
+	admin.Email = normalizeEmail(admin.Email)
+	if admin.Email == "" || !strings.Contains(admin.Email, "@") {
+		return 0, errors.New("a valid email address must be provided")
+	}
+	if !ValidRole(admin.Role) {
+		return 0, fmt.Errorf("invalid role %q", admin.Role)
+	}
+
+	err := transact(db, func(tx *sqlx.Tx) error {
+		var existing []int
+		if err := tx.Select(&existing, `SELECT id FROM admins WHERE email = ? AND conference_id <=> ? FOR UPDATE`, admin.Email, admin.ConferenceID); err != nil {
+			return fmt.Errorf("failed to look up admin: %w", err)
+		}
+		if len(existing) > 0 {
+			admin.ID = existing[0]
+			if _, err := tx.NamedExec(`UPDATE admins SET role = :role, invited_by = :invited_by WHERE id = :id`, admin); err != nil {
+				return fmt.Errorf("failed to update admin: %w", err)
+			}
+			return ensureOwnerRemains(tx)
+		}
+		res, err := tx.NamedExec(`
+INSERT INTO admins (email, conference_id, role, invited_by, timestamp)
+VALUES (:email, :conference_id, :role, :invited_by, NOW())
+`, admin)
+		if err != nil {
+			return fmt.Errorf("failed to insert admin: %w", err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get inserted admin id: %w", err)
+		}
+		admin.ID = int(id)
+		return nil
+	})
+	return admin.ID, err
+}
+
+func DeleteAdmin(db DB, id string) error {
+	if id == "" {
+		return errors.New("admin id must be provided")
+	}
+	return transact(db, func(tx *sqlx.Tx) error {
+		res, err := tx.Exec(`DELETE FROM admins WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete admin: %w", err)
+		}
+		if rows, _ := res.RowsAffected(); rows == 0 {
+			return fmt.Errorf("failed to delete admin: no rows affected")
+		}
+		return ensureOwnerRemains(tx)
+	})
+}
+
+// adminConferenceCondition returns an SQL condition that holds if the
+// admin whose email is its argument has a role for the conference whose
+// ID is expr, or for all conferences.
+func adminConferenceCondition(expr string) string {
+	return `EXISTS (SELECT 1 FROM admins WHERE admins.email = ? AND (admins.conference_id IS NULL OR admins.conference_id = ` + expr + `))`
+}
+
+// ensureOwnerRemains returns an error if there is no longer anybody
+// who owns all conferences, since then nobody could manage admins.
+func ensureOwnerRemains(tx *sqlx.Tx) error {
+	var owners int
+	if err := tx.Get(&owners, `SELECT COUNT(*) FROM admins WHERE role = ? AND conference_id IS NULL`, RoleOwner); err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+	if owners == 0 {
+		return errors.New("cannot remove the last owner")
+	}
+	return nil
+}
+
+// EnsureOwner makes email an owner of all conferences, if it isn't
+// already. It is used to bootstrap access to a new deployment.
+func EnsureOwner(db *sqlx.DB, email string) error {
+	role, err := GetAdminRole(db, email, 0)
+	if err != nil {
+		return err
+	}
+	if role == RoleOwner {
+		return nil
+	}
+	_, err = SaveAdmin(db, Admin{Email: email, Role: RoleOwner})
+	return err
+}
+
+// normalizeEmail lowercases email, since Google account emails aren't
+// case sensitive.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -1,50 +1,119 @@
 package model
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type Announcement struct {
-	ID           int    `db:"id"`
-	ConferenceID int    `db:"conference_id"`
-	Title        string `db:"title"`
-	Message      string `db:"message"`
-	LongMessage  string `db:"long_message"`
-	Icon         string `db:"icon"`
-	URL          string `db:"url"`
-	URLText      string `db:"url_text"`
-	CreatedBy    string `db:"created_by"`
-	SendTime     string `db:"send_time"`
-	Sent         bool   `db:"sent"`
+	ID           int       `db:"id"`
+	ConferenceID int       `db:"conference_id"`
+	Title        string    `db:"title"`
+	Message      string    `db:"message"`
+	LongMessage  string    `db:"long_message"`
+	Icon         string    `db:"icon"`
+	URL          string    `db:"url"`
+	URLText      string    `db:"url_text"`
+	CreatedBy    string    `db:"created_by"`
+	UpdatedBy    string    `db:"updated_by"`
+	SendTime     time.Time `db:"send_time"`
+	Sent         bool      `db:"sent"`
+	Retracted    bool      `db:"retracted"`
+
+	// An announcement starts as a draft, and is only sent once it has
+	// been approved by an admin other than its author and the one who
+	// last changed it.
+	State        string         `db:"state"`
+	ApprovedBy   sql.NullString `db:"approved_by"`
+	ApprovedTime sql.NullTime   `db:"approved_time"`
+
+	// Pinned announcements are listed first in the app, and expired
+	// ones aren't listed at all.
+	ExpiresAt sql.NullTime `db:"expires_at"`
+	Pinned    bool         `db:"pinned"`
+
+	// The announcement is only sent to users matching all of the
+	// targeting fields that are set. If none are set, it goes to
+	// everyone in the conference.
+	TargetEventID         sql.NullInt64 `db:"target_event_id"`
+	TargetPlatform        string        `db:"target_platform"`
+	TargetRegisteredAfter sql.NullTime  `db:"target_registered_after"`
+	TargetUserIDs         []int         `db:"-"`
+
+	// Deep-link target opened when the push notification is tapped.
+	LinkEventID sql.NullInt64 `db:"link_event_id"`
+	LinkInfoID  sql.NullInt64 `db:"link_info_id"`
+
+	// Push delivery options. See expo.PushMessage for their meaning.
+	Badge      sql.NullInt64 `db:"badge"`
+	Sound      string        `db:"sound"`
+	ChannelID  string        `db:"channel_id"`
+	Priority   string        `db:"priority"`
+	TTLSeconds int           `db:"ttl_seconds"`
+	// Urgent announcements are pushed even during users' quiet hours.
+	Urgent bool `db:"urgent"`
+
+	// DeletedAt is set while the announcement is in the trash.
+	DeletedAt sql.NullTime `db:"deleted_at"`
+
+	// Edited reports whether the announcement has been revised since
+	// it was sent. It is only populated by ListPublishedAnnouncements.
+	Edited bool `db:"edited"`
+
+	// Notification counts by status. These are only populated by
+	// ListAnnouncements.
+	QueuedNotifications int `db:"queued_notifications"`
+	LeasedNotifications int `db:"leased_notifications"`
+	SentNotifications   int `db:"sent_notifications"`
+	FailedNotifications int `db:"failed_notifications"`
 }
 
+const (
+	AnnouncementStateDraft    = "draft"
+	AnnouncementStateApproved = "approved"
+)
+
 type AnnouncementOptions struct {
-	IncludeScheduled       bool
-	ConvertTimeToUSPacific bool
+	IncludeScheduled bool
+	DraftsOnly       bool
+	// Admin, if set, limits the announcements to those of conferences
+	// that the admin with this email has a role for.
+	Admin string
 }
 
 func ListAnnouncements(db *sqlx.DB, options AnnouncementOptions) ([]Announcement, error) {
-	timeQuery := "send_time"
-	if options.ConvertTimeToUSPacific {
-		timeQuery = `DATE_FORMAT(CONVERT_TZ(send_time, 'UTC','US/Pacific'), "%a, %b %e, %Y at %l:%i %p") as send_time`
-	}
-
 	query := `
-SELECT id, conference_id, title, message, long_message, icon, created_by,
-       ` + timeQuery + `,
-       sent, url, url_text
+SELECT id, conference_id, title, message, long_message, icon, created_by, updated_by, state, approved_by,
+       send_time, sent, retracted, expires_at, pinned, url, url_text,
+       COALESCE(progress.queued, 0) as queued_notifications,
+       COALESCE(progress.leased, 0) as leased_notifications,
+       COALESCE(progress.sent, 0) as sent_notifications,
+       COALESCE(progress.failed, 0) as failed_notifications
 FROM announcements
-ORDER BY announcements.send_time desc
+LEFT JOIN (` + notificationProgressQuery + `) progress ON progress.announcement_id = announcements.id
+WHERE announcements.deleted_at IS NULL
 `
+	var args []interface{}
 	if !options.IncludeScheduled {
-		query += " AND sent = 1"
+		query += " AND sent"
+	}
+	if options.DraftsOnly {
+		query += ` AND state = "draft"`
 	}
+	if options.Admin != "" {
+		query += " AND " + adminConferenceCondition("announcements.conference_id")
+		args = append(args, normalizeEmail(options.Admin))
+	}
+	query += " ORDER BY announcements.send_time desc"
 	var announcements []Announcement
-	if err := db.Select(&announcements, query); err != nil {
+	if err := db.Select(&announcements, query, args...); err != nil {
 		return announcements, fmt.Errorf("failed to list announcements: %w", err)
 	}
 	if announcements == nil {
@@ -53,9 +122,38 @@ ORDER BY announcements.send_time desc
 	return announcements, nil
 }
 
-func GetAnnouncementByID(db *sqlx.DB, id string) (Announcement, error) {
+// ListPublishedAnnouncements returns the announcements that the app
+// lists for the conference: those that have been sent, and haven't
+// been retracted, moved to the trash, or expired. Pinned announcements
+// come first, and then the most recently sent.
+func ListPublishedAnnouncements(ctx context.Context, db *sqlx.DB, conferenceID int) ([]Announcement, error) {
+	const query = `
+SELECT a.id, a.conference_id, a.title, a.message, a.long_message, a.icon, a.url, a.url_text, a.created_by,
+       a.send_time, a.sent, a.retracted, a.expires_at, a.pinned, a.link_event_id, a.link_info_id,
+       EXISTS (SELECT 1 FROM announcement_revisions r WHERE r.announcement_id = a.id) AS edited
+FROM announcements a
+JOIN conferences c ON c.id = a.conference_id
+WHERE a.conference_id = ?
+  AND a.sent AND NOT a.retracted
+  AND a.deleted_at IS NULL AND c.deleted_at IS NULL
+  AND (a.expires_at IS NULL OR a.expires_at > UTC_TIMESTAMP())
+ORDER BY a.pinned DESC, a.send_time DESC, a.id DESC
+`
+	var announcements []Announcement
+	if err := db.SelectContext(ctx, &announcements, query, conferenceID); err != nil {
+		return nil, fmt.Errorf("failed to list announcements: %w", err)
+	}
+	if announcements == nil {
+		announcements = make([]Announcement, 0)
+	}
+	return announcements, nil
+}
+
+func GetAnnouncementByID(db DB, id string) (Announcement, error) {
 	const query = `
-SELECT id, conference_id, title, message, long_message, icon, created_by, send_time, sent, url, url_text
+SELECT id, conference_id, title, message, long_message, icon, created_by, updated_by, state, approved_by, approved_time, send_time, sent, retracted, expires_at, pinned, url, url_text,
+       target_event_id, target_platform, target_registered_after,
+       link_event_id, link_info_id, badge, sound, channel_id, priority, ttl_seconds, urgent, deleted_at
 FROM announcements
 WHERE id = ?
 `
@@ -66,45 +164,369 @@ WHERE id = ?
 	if len(announcements) == 0 {
 		return Announcement{}, errors.New("found no announcements with given id")
 	}
-	return announcements[0], nil
+	announcement := announcements[0]
+	if err := db.Select(&announcement.TargetUserIDs, "SELECT user_id FROM announcement_target_users WHERE announcement_id = ? ORDER BY user_id", id); err != nil {
+		return Announcement{}, fmt.Errorf("failed to select announcement target users: %w", err)
+	}
+	return announcement, nil
 }
 
-func SaveAnnouncement(db *sqlx.DB, announcement Announcement) error {
-	if announcement.ID == 0 {
-		return insertAnnouncement(db, announcement)
-	}
-	return updateAnnouncement(db, announcement)
+// SaveAnnouncement inserts or updates the announcement, and returns
+// its ID. It always leaves the announcement as a draft, since any
+// change needs to be approved again before it is sent. CreatedBy is
+// only saved when the announcement is inserted.
+func SaveAnnouncement(db DB, announcement Announcement) (int, error) {
+	err := transact(db, func(tx *sqlx.Tx) error {
+		if announcement.ID == 0 {
+			id, err := insertAnnouncement(tx, announcement)
+			if err != nil {
+				return err
+			}
+			announcement.ID = id
+		} else if err := updateAnnouncement(tx, announcement); err != nil {
+			return err
+		}
+		return saveAnnouncementTargetUsers(tx, announcement)
+	})
+	return announcement.ID, err
 }
 
-func insertAnnouncement(db *sqlx.DB, announcement Announcement) error {
+func insertAnnouncement(tx *sqlx.Tx, announcement Announcement) (int, error) {
 	log.Println("inserting!")
 	query := `
-INSERT INTO announcements (conference_id, title, message, long_message, icon, created_by, send_time, url, url_text)
-VALUES (:conference_id, TRIM(:title), TRIM(:message), TRIM(:long_message), :icon, :created_by, :send_time, :url, :url_text)
+INSERT INTO announcements (conference_id, title, message, long_message, icon, created_by, updated_by, send_time, url, url_text, expires_at, pinned,
+                           target_event_id, target_platform, target_registered_after,
+                           link_event_id, link_info_id, badge, sound, channel_id, priority, ttl_seconds, urgent)
+VALUES (:conference_id, TRIM(:title), TRIM(:message), TRIM(:long_message), :icon, :created_by, :updated_by, :send_time, :url, :url_text, :expires_at, :pinned,
+        :target_event_id, :target_platform, :target_registered_after,
+        :link_event_id, :link_info_id, :badge, :sound, TRIM(:channel_id), :priority, :ttl_seconds, :urgent)
 `
-	if _, err := db.NamedExec(query, announcement); err != nil {
-		return fmt.Errorf("failed to insert announcement: %w", err)
+	res, err := tx.NamedExec(query, announcement)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert announcement: %w", err)
 	}
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inserted announcement id: %w", err)
+	}
+	return int(id), nil
 }
 
-func updateAnnouncement(db *sqlx.DB, announcement Announcement) error {
+func updateAnnouncement(tx *sqlx.Tx, announcement Announcement) error {
 	query := `
 UPDATE announcements
-SET conference_id = :conference_id, title = TRIM(:title), message = TRIM(:message), long_message = TRIM(:long_message), icon = :icon, created_by = :created_by, send_time = :send_time, url = TRIM(:url), url_text = TRIM(:url_text)
+SET conference_id = :conference_id, title = TRIM(:title), message = TRIM(:message), long_message = TRIM(:long_message), icon = :icon, updated_by = :updated_by, send_time = :send_time, url = TRIM(:url), url_text = TRIM(:url_text),
+    expires_at = :expires_at, pinned = :pinned,
+    state = "draft", approved_by = NULL, approved_time = NULL,
+    target_event_id = :target_event_id, target_platform = :target_platform, target_registered_after = :target_registered_after,
+    link_event_id = :link_event_id, link_info_id = :link_info_id, badge = :badge, sound = :sound, channel_id = TRIM(:channel_id),
+    priority = :priority, ttl_seconds = :ttl_seconds, urgent = :urgent
 WHERE id = :id
 `
-	if _, err := db.NamedExec(query, announcement); err != nil {
+	if _, err := tx.NamedExec(query, announcement); err != nil {
 		return fmt.Errorf("failed to update announcement: %w", err)
 	}
 	return nil
 }
 
-func DeleteAnnouncement(db *sqlx.DB, id string) error {
+func saveAnnouncementTargetUsers(tx *sqlx.Tx, announcement Announcement) error {
+	if _, err := tx.Exec("DELETE FROM announcement_target_users WHERE announcement_id = ?", announcement.ID); err != nil {
+		return fmt.Errorf("failed to clear announcement target users: %w", err)
+	}
+	for _, userID := range announcement.TargetUserIDs {
+		if _, err := tx.Exec("INSERT IGNORE INTO announcement_target_users (announcement_id, user_id) VALUES (?, ?)", announcement.ID, userID); err != nil {
+			return fmt.Errorf("failed to save announcement target user %d: %w", userID, err)
+		}
+	}
+	return nil
+}
+
+// CountAnnouncementRecipients returns the number of users with push
+// notifications enabled who would receive the announcement if it
+// were sent now.
+func CountAnnouncementRecipients(db *sqlx.DB, announcement Announcement) (int, error) {
+	// The announcement may not have been saved, so its audience is
+	// evaluated against a row of its fields instead.
+	query := `
+SELECT COUNT(*)
+FROM (
+	SELECT ? AS conference_id, ? AS icon, ? AS target_event_id, ? AS target_platform,
+	       CAST(? AS DATETIME) AS target_registered_after
+) announcements
+JOIN users ON users.conference_id = announcements.conference_id
+WHERE ` + hasPushToken + `
+	AND `
+	args := []interface{}{
+		announcement.ConferenceID, announcement.Icon, announcement.TargetEventID,
+		announcement.TargetPlatform, announcement.TargetRegisteredAfter,
+	}
+	if len(announcement.TargetUserIDs) > 0 {
+		query += announcementAudience("users.id IN (?)")
+		args = append(args, announcement.TargetUserIDs)
+	} else {
+		query += announcementAudience("TRUE")
+	}
+
+	query, args, err := sqlx.In(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare query using IN clause: %w", err)
+	}
+	var count int
+	if err := db.Get(&count, query, args...); err != nil {
+		return 0, fmt.Errorf("failed to count announcement recipients: %w", err)
+	}
+	return count, nil
+}
+
+// ApproveAnnouncement approves a draft announcement so that it will
+// be sent at its send time. Admins can't approve announcements they
+// wrote or last changed.
+func ApproveAnnouncement(db DB, id string, approvedBy string) error {
+	return transact(db, func(tx *sqlx.Tx) error {
+		var announcement Announcement
+		if err := tx.Get(&announcement, "SELECT id, created_by, updated_by, state FROM announcements WHERE id = ? FOR UPDATE", id); err != nil {
+			return fmt.Errorf("failed to select announcement: %w", err)
+		}
+		if announcement.State != AnnouncementStateDraft {
+			return errors.New("announcement is not awaiting approval")
+		}
+		if announcement.CreatedBy == approvedBy || announcement.UpdatedBy == approvedBy {
+			return errors.New("announcement must be approved by someone other than its author and last editor")
+		}
+		const query = `
+UPDATE announcements
+SET state = "approved", approved_by = ?, approved_time = UTC_TIMESTAMP
+WHERE id = ?
+`
+		if _, err := tx.Exec(query, approvedBy, id); err != nil {
+			return fmt.Errorf("failed to approve announcement: %w", err)
+		}
+		return nil
+	})
+}
+
+const (
+	RevisionActionEdit    = "edit"
+	RevisionActionRetract = "retract"
+)
+
+// AnnouncementRevision records an announcement's content as it was
+// before being edited or retracted after it was sent.
+type AnnouncementRevision struct {
+	ID             int    `db:"id"`
+	AnnouncementID int    `db:"announcement_id"`
+	Action         string `db:"action"`
+	Title          string `db:"title"`
+	Message        string `db:"message"`
+	LongMessage    string `db:"long_message"`
+	URL            string `db:"url"`
+	URLText        string `db:"url_text"`
+	// Notice is the body of the follow-up push sent to the users who
+	// received the announcement, or empty if none was sent.
+	Notice    string    `db:"notice"`
+	CreatedBy string    `db:"created_by"`
+	Timestamp time.Time `db:"timestamp"`
+
+	// Like announcements, a notice is only sent once it has been
+	// approved by an admin other than the one who wrote it. Revisions
+	// without a notice need no approval.
+	State        string         `db:"state"`
+	ApprovedBy   sql.NullString `db:"approved_by"`
+	ApprovedTime sql.NullTime   `db:"approved_time"`
+	Sent         bool           `db:"sent"`
+}
+
+const announcementRevisionColumns = `
+	r.id, r.announcement_id, r.action, r.title, r.message, r.long_message, r.url, r.url_text, r.notice, r.created_by, r.timestamp,
+	r.state, r.approved_by, r.approved_time, r.sent
+`
+
+func ListAnnouncementRevisions(db *sqlx.DB, announcementID string) ([]AnnouncementRevision, error) {
+	query := `
+SELECT ` + announcementRevisionColumns + `
+FROM announcement_revisions r
+WHERE r.announcement_id = ?
+ORDER BY r.id desc
+`
+	var revisions []AnnouncementRevision
+	if err := db.Select(&revisions, query, announcementID); err != nil {
+		return nil, fmt.Errorf("failed to list announcement revisions: %w", err)
+	}
+	return revisions, nil
+}
+
+// ListDraftRevisions returns the revisions whose notices are awaiting
+// approval. If admin is set, only revisions of announcements of
+// conferences they have a role for are listed.
+func ListDraftRevisions(db *sqlx.DB, admin string) ([]AnnouncementRevision, error) {
+	query := `
+SELECT ` + announcementRevisionColumns + `
+FROM announcement_revisions r
+JOIN announcements ON announcements.id = r.announcement_id
+WHERE r.state = "draft" AND announcements.deleted_at IS NULL
+`
+	var args []interface{}
+	if admin != "" {
+		query += " AND " + adminConferenceCondition("announcements.conference_id")
+		args = append(args, normalizeEmail(admin))
+	}
+	query += " ORDER BY r.id"
+	var revisions []AnnouncementRevision
+	if err := db.Select(&revisions, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list draft revisions: %w", err)
+	}
+	if revisions == nil {
+		revisions = make([]AnnouncementRevision, 0)
+	}
+	return revisions, nil
+}
+
+func GetAnnouncementRevisionByID(db DB, id string) (AnnouncementRevision, error) {
+	query := `
+SELECT ` + announcementRevisionColumns + `
+FROM announcement_revisions r
+WHERE r.id = ?
+`
+	var revisions []AnnouncementRevision
+	if err := db.Select(&revisions, query, id); err != nil {
+		return AnnouncementRevision{}, fmt.Errorf("failed to select announcement revision: %w", err)
+	}
+	if len(revisions) == 0 {
+		return AnnouncementRevision{}, errors.New("found no announcement revision with given id")
+	}
+	return revisions[0], nil
+}
+
+// ApproveAnnouncementRevision approves a revision's notice so that it
+// is sent. Admins can't approve their own notices.
+func ApproveAnnouncementRevision(db DB, id string, approvedBy string) error {
+	return transact(db, func(tx *sqlx.Tx) error {
+		var revision AnnouncementRevision
+		if err := tx.Get(&revision, "SELECT id, created_by, state FROM announcement_revisions WHERE id = ? FOR UPDATE", id); err != nil {
+			return fmt.Errorf("failed to select announcement revision: %w", err)
+		}
+		if revision.State != AnnouncementStateDraft {
+			return errors.New("notice is not awaiting approval")
+		}
+		if revision.CreatedBy == approvedBy {
+			return errors.New("notice must be approved by someone other than its author")
+		}
+		const query = `
+UPDATE announcement_revisions
+SET state = "approved", approved_by = ?, approved_time = UTC_TIMESTAMP
+WHERE id = ?
+`
+		if _, err := tx.Exec(query, approvedBy, id); err != nil {
+			return fmt.Errorf("failed to approve notice: %w", err)
+		}
+		return nil
+	})
+}
+
+// insertAnnouncementRevision saves the announcement's current content
+// as a revision. If notice is non-empty, it is left for another admin
+// to approve before it is sent.
+func insertAnnouncementRevision(tx *sqlx.Tx, announcementID int, action, createdBy, notice string) error {
+	const query = `
+INSERT INTO announcement_revisions (announcement_id, action, title, message, long_message, url, url_text, notice, created_by, state)
+SELECT id, ?, title, message, long_message, url, url_text, TRIM(?), ?, IF(TRIM(?) = "", "approved", "draft")
+FROM announcements
+WHERE id = ? AND sent AND NOT retracted
+`
+	res, err := tx.Exec(query, action, notice, createdBy, notice, announcementID)
+	if err != nil {
+		return fmt.Errorf("failed to insert announcement revision: %w", err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("announcement has not been sent or has been retracted")
+	}
+	return nil
+}
+
+// ReviseAnnouncement updates an announcement that has already been
+// sent. If its content changed, the previous content is kept as a
+// revision, and if notice is non-empty, it is pushed to everyone who
+// received the announcement to let them know it changed, once another
+// admin approves it.
+//
+// The audience, send time, and push options of a sent announcement
+// can't be changed, so only its content, expiry, and pinning are
+// updated. Changing only its expiry or pinning doesn't count as an
+// edit, so it can be done even once the announcement is retracted.
+func ReviseAnnouncement(db DB, announcement Announcement, editedBy, notice string) error {
+	return transact(db, func(tx *sqlx.Tx) error {
+		var current Announcement
+		if err := tx.Get(&current, "SELECT title, message, long_message, icon, url, url_text FROM announcements WHERE id = ? AND sent FOR UPDATE", announcement.ID); err != nil {
+			return fmt.Errorf("failed to get announcement: %w", err)
+		}
+
+		if _, err := tx.NamedExec("UPDATE announcements SET expires_at = :expires_at, pinned = :pinned WHERE id = :id", announcement); err != nil {
+			return fmt.Errorf("failed to update announcement listing: %w", err)
+		}
+		if !announcementContentChanged(current, announcement) {
+			return nil
+		}
+
+		if err := insertAnnouncementRevision(tx, announcement.ID, RevisionActionEdit, editedBy, notice); err != nil {
+			return err
+		}
+		announcement.UpdatedBy = editedBy
+		const query = `
+UPDATE announcements
+SET title = TRIM(:title), message = TRIM(:message), long_message = TRIM(:long_message), icon = :icon, url = TRIM(:url), url_text = TRIM(:url_text),
+    updated_by = :updated_by
+WHERE id = :id
+`
+		if _, err := tx.NamedExec(query, announcement); err != nil {
+			return fmt.Errorf("failed to update announcement: %w", err)
+		}
+		return nil
+	})
+}
+
+// announcementContentChanged reports whether revised has different
+// content from current, once trimmed the way it is when saved.
+func announcementContentChanged(current, revised Announcement) bool {
+	// MySQL's TRIM only removes spaces.
+	trim := func(s string) string { return strings.Trim(s, " ") }
+	return current.Title != trim(revised.Title) ||
+		current.Message != trim(revised.Message) ||
+		current.LongMessage != trim(revised.LongMessage) ||
+		current.Icon != revised.Icon ||
+		current.URL != trim(revised.URL) ||
+		current.URLText != trim(revised.URLText)
+}
+
+// RetractAnnouncement hides a sent announcement from the app and
+// cancels any of its notifications that haven't gone out yet. If
+// notice is non-empty, it is pushed to everyone who received the
+// announcement once another admin approves it.
+func RetractAnnouncement(db DB, announcementID int, retractedBy, notice string) error {
+	return transact(db, func(tx *sqlx.Tx) error {
+		if err := insertAnnouncementRevision(tx, announcementID, RevisionActionRetract, retractedBy, notice); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE announcements SET retracted = TRUE WHERE id = ?", announcementID); err != nil {
+			return fmt.Errorf("failed to retract announcement: %w", err)
+		}
+		// Leased notifications are being sent right now, so leave
+		// them to the sender. SelectNotificationsToSend cancels them
+		// if their lease expires, or the sender queues them to retry.
+		if _, err := tx.Exec(`DELETE FROM notifications WHERE announcement_id = ? AND status = "Queued"`, announcementID); err != nil {
+			return fmt.Errorf("failed to cancel queued notifications: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteAnnouncement moves the announcement to the trash. It stops
+// being listed in the app, and any of its notifications that haven't
+// gone out yet are held until it is restored.
+func DeleteAnnouncement(db DB, id string) error {
 	if id == "" {
 		return errors.New("announcement id must be provided")
 	}
-	const query = "DELETE FROM announcements WHERE id = ?"
+	const query = "UPDATE announcements SET deleted_at = UTC_TIMESTAMP() WHERE id = ? AND deleted_at IS NULL"
 	res, err := db.Exec(query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete announcement: %w", err)
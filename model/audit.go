@@ -0,0 +1,228 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Entity types recorded in the audit log.
+const (
+	AuditEntityConference   = "conference"
+	AuditEntityLocation     = "location"
+	AuditEntityEvent        = "event"
+	AuditEntityInfo         = "info"
+	AuditEntityAnnouncement = "announcement"
+	AuditEntityAdmin        = "admin"
+)
+
+// AuditEntityTypes lists the entity types, for filtering the audit log.
+var AuditEntityTypes = []string{
+	AuditEntityConference,
+	AuditEntityLocation,
+	AuditEntityEvent,
+	AuditEntityInfo,
+	AuditEntityAnnouncement,
+	AuditEntityAdmin,
+}
+
+// Actions recorded in the audit log. Announcements also have actions
+// of their own, such as "approve" and "retract".
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+	// Entities that can be moved to the trash are deleted by moving
+	// them there, and may later be restored or purged from it.
+	AuditActionRestore = "restore"
+	AuditActionPurge   = "purge"
+)
+
+// AuditEntry records a change an admin made to an entity.
+type AuditEntry struct {
+	ID         int    `db:"id"`
+	Actor      string `db:"actor"`
+	EntityType string `db:"entity_type"`
+	EntityID   int    `db:"entity_id"`
+	Action     string `db:"action"`
+	// Changes is a JSON object mapping the name of each field that
+	// changed to its "before" and "after" values.
+	Changes   string    `db:"changes"`
+	Timestamp time.Time `db:"timestamp"`
+}
+
+// FieldChange is a field's value before and after a change. Before is
+// nil for entities that were created, and After is nil for entities
+// that were deleted.
+type FieldChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// ChangedFields decodes the entry's Changes, for display.
+func (e AuditEntry) ChangedFields() (map[string]FieldChange, error) {
+	var changes map[string]FieldChange
+	if err := json.Unmarshal([]byte(e.Changes), &changes); err != nil {
+		return nil, fmt.Errorf("failed to decode audit changes: %w", err)
+	}
+	return changes, nil
+}
+
+// AuditChanges returns the JSON-encoded diff between two states of an
+// entity, which are structs or nil if the entity didn't exist. Only
+// fields whose values differ are included, so fields that are null
+// in the only state there is are left out too.
+func AuditChanges(before, after interface{}) (string, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return "", err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return "", err
+	}
+
+	changes := make(map[string]FieldChange)
+	for name, b := range beforeFields {
+		if a := afterFields[name]; !reflect.DeepEqual(a, b) {
+			changes[name] = FieldChange{Before: b, After: a}
+		}
+	}
+	for name, a := range afterFields {
+		if _, ok := beforeFields[name]; !ok && a != nil {
+			changes[name] = FieldChange{After: a}
+		}
+	}
+
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+	return string(b), nil
+}
+
+// auditFields converts an entity struct to a map from its column
+// names to their values, so that states can be compared field by
+// field. Nullable values are unwrapped to nil or their value.
+func auditFields(entity interface{}) (map[string]interface{}, error) {
+	if entity == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(entity)
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot audit %T", entity)
+	}
+	fields := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := f.Tag.Get("db")
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		value := v.Field(i).Interface()
+		if valuer, ok := value.(driver.Valuer); ok {
+			var err error
+			if value, err = valuer.Value(); err != nil {
+				return nil, fmt.Errorf("failed to get %v for audit: %w", name, err)
+			}
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+func InsertAuditEntry(db DB, entry AuditEntry) error {
+	query := `
+INSERT INTO audit_log (actor, entity_type, entity_id, action, changes, timestamp)
+VALUES (:actor, :entity_type, :entity_id, :action, :changes, NOW())
+`
+	if _, err := db.NamedExec(query, entry); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+type AuditOptions struct {
+	Actor      string
+	EntityType string
+	EntityID   int
+	// Search matches entries whose changes contain the text, ignoring
+	// case.
+	Search string
+	// Admin, if set, limits the entries to those about the conferences
+	// that the admin with this email has a role for, and about data
+	// shared by all conferences.
+	Admin string
+	Limit int
+}
+
+// auditConferenceID is the ID of the conference that an audit entry
+// is about, or null for locations, info, and admins.
+const auditConferenceID = `CASE audit_log.entity_type
+	WHEN 'conference' THEN audit_log.entity_id
+	WHEN 'event' THEN (SELECT conference_id FROM events WHERE events.id = audit_log.entity_id)
+	WHEN 'announcement' THEN (SELECT conference_id FROM announcements WHERE announcements.id = audit_log.entity_id)
+END`
+
+// ListAuditEntries returns matching audit entries, newest first.
+func ListAuditEntries(db *sqlx.DB, options AuditOptions) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if options.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, normalizeEmail(options.Actor))
+	}
+	if options.EntityType != "" {
+		conditions = append(conditions, "entity_type = ?")
+		args = append(args, options.EntityType)
+	}
+	if options.EntityID != 0 {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, options.EntityID)
+	}
+	if options.Search != "" {
+		conditions = append(conditions, "LOWER(CAST(changes AS CHAR)) LIKE LOWER(?)")
+		args = append(args, "%"+escapeLike(options.Search)+"%")
+	}
+	if options.Admin != "" {
+		// Changes to admins are only shown to admins of all
+		// conferences, since auditConferenceID is null for them.
+		conditions = append(conditions, "(entity_type IN ('location', 'info') OR "+adminConferenceCondition(auditConferenceID)+")")
+		args = append(args, normalizeEmail(options.Admin))
+	}
+	if options.Limit == 0 {
+		options.Limit = 200
+	}
+
+	query := `
+SELECT id, actor, entity_type, entity_id, action, changes, timestamp
+FROM audit_log
+WHERE 1`
+	for _, c := range conditions {
+		query += " AND " + c
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, options.Limit)
+
+	var entries []AuditEntry
+	if err := db.Select(&entries, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	if entries == nil {
+		entries = make([]AuditEntry, 0)
+	}
+	return entries, nil
+}
+
+// escapeLike escapes the wildcards in s for use in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,40 +1,49 @@
 package model
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type Conference struct {
-	ID        int    `db:"id"`
-	Name      string `db:"name"`
-	StartDate string `db:"start_date"`
-	EndDate   string `db:"end_date"`
-	StartDateUtc string `db:"start_date_utc"`
-	EndDateUtc   string `db:"end_date_utc"`
+	ID        int       `db:"id"`
+	Name      string    `db:"name"`
+	StartDate time.Time `db:"start_date"`
+	EndDate   time.Time `db:"end_date"`
+	// Timezone is the IANA name of the time zone the conference is
+	// held in, which its times are entered and shown in.
+	Timezone string `db:"timezone"`
+	// ReminderMinutes is how long before an event starts to remind
+	// attendees about it. Zero disables reminders.
+	ReminderMinutes int `db:"reminder_minutes"`
+	// DeletedAt is set while the conference is in the trash.
+	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
-type ConferenceOptions struct {
-	ConvertTimeToUSPacific bool
-}
-
-func ListConferences(db *sqlx.DB, options ConferenceOptions) ([]Conference, error) {
+// DefaultTimezone is the time zone of new conferences.
+const DefaultTimezone = "America/Los_Angeles"
 
-	startTimeQuery := "start_date"
-	endTimeQuery := "end_date"
-	if options.ConvertTimeToUSPacific {
-		startTimeQuery = `DATE_FORMAT(CONVERT_TZ(start_date, 'UTC','US/Pacific'), "%a, %b %e, %Y at %l:%i %p") as start_date`
-		endTimeQuery = `DATE_FORMAT(CONVERT_TZ(end_date, 'UTC','US/Pacific'), "%a, %b %e, %Y at %l:%i %p") as end_date`
+// TimeZone returns the conference's time zone.
+func (c Conference) TimeZone() (*time.Location, error) {
+	// LoadLocation treats "" as UTC and "Local" as the server's time
+	// zone, neither of which are what an admin meant.
+	if c.Timezone == "" || c.Timezone == "Local" {
+		return nil, fmt.Errorf("invalid time zone %q", c.Timezone)
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q", c.Timezone)
 	}
+	return loc, nil
+}
 
-	query := `SELECT id, name,` +
-		startTimeQuery +
-		`,` +
-		endTimeQuery +
-		`, start_date as start_date_utc, end_date as end_date_utc FROM conferences`
+func ListConferences(db *sqlx.DB) ([]Conference, error) {
+	query := `SELECT id, name, start_date, end_date, timezone, reminder_minutes FROM conferences WHERE deleted_at IS NULL ORDER BY start_date DESC`
 	var conferences []Conference
 	if err := db.Select(&conferences, query); err != nil {
 		return conferences, fmt.Errorf("failed to list conferences: %w", err)
@@ -45,9 +54,9 @@ func ListConferences(db *sqlx.DB, options ConferenceOptions) ([]Conference, erro
 	return conferences, nil
 }
 
-func GetConferenceByID(db *sqlx.DB, id string) (Conference, error) {
+func GetConferenceByID(db DB, id string) (Conference, error) {
 	const query = `
-SELECT id, name, start_date, end_date
+SELECT id, name, start_date, end_date, timezone, reminder_minutes, deleted_at
 FROM conferences
 WHERE id = ?
 `
@@ -61,39 +70,60 @@ WHERE id = ?
 	return conferences[0], nil
 }
 
-func SaveConference(db *sqlx.DB, conference Conference) error {
+// GetListedConference returns the conference unless it is in the
+// trash. It returns sql.ErrNoRows if there is no such conference.
+func GetListedConference(ctx context.Context, db *sqlx.DB, id int) (Conference, error) {
+	const query = `
+SELECT id, name, start_date, end_date, timezone, reminder_minutes
+FROM conferences
+WHERE id = ? AND deleted_at IS NULL
+`
+	var conference Conference
+	err := db.GetContext(ctx, &conference, query, id)
+	return conference, err
+}
+
+// SaveConference inserts or updates the conference, and returns its ID.
+func SaveConference(db DB, conference Conference) (int, error) {
+	if _, err := conference.TimeZone(); err != nil {
+		return 0, err
+	}
 	if conference.ID == 0 {
 		return insertConference(db, conference)
 	}
-	return updateConference(db, conference)
+	return conference.ID, updateConference(db, conference)
 }
 
-func insertConference(db *sqlx.DB, conference Conference) error {
-	query := "INSERT INTO conferences (name, start_date, end_date) VALUES (TRIM(:name), :start_date, :end_date)"
-	if _, err := db.NamedExec(query, conference); err != nil {
-		return fmt.Errorf("failed to insert conference: %w", err)
+func insertConference(db DB, conference Conference) (int, error) {
+	query := "INSERT INTO conferences (name, start_date, end_date, timezone, reminder_minutes) VALUES (TRIM(:name), :start_date, :end_date, :timezone, :reminder_minutes)"
+	res, err := db.NamedExec(query, conference)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert conference: %w", err)
 	}
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inserted conference id: %w", err)
+	}
+	return int(id), nil
 }
 
-func updateConference(db *sqlx.DB, conference Conference) error {
-	query := "UPDATE conferences SET name = TRIM(:name), start_date = :start_date, end_date = :end_date WHERE id = :id"
+func updateConference(db DB, conference Conference) error {
+	query := "UPDATE conferences SET name = TRIM(:name), start_date = :start_date, end_date = :end_date, timezone = :timezone, reminder_minutes = :reminder_minutes WHERE id = :id"
 	if _, err := db.NamedExec(query, conference); err != nil {
 		return fmt.Errorf("failed to update conference: %w", err)
 	}
 	return nil
 }
 
-func DeleteConference(db *sqlx.DB, id string) error {
+// DeleteConference moves the conference to the trash, which hides it
+// and its events and announcements from the app.
+func DeleteConference(db DB, id string) error {
 	if id == "" {
 		return errors.New("conference id must be provided")
 	}
-	const query = "DELETE FROM conferences WHERE id = ?"
+	const query = "UPDATE conferences SET deleted_at = UTC_TIMESTAMP() WHERE id = ? AND deleted_at IS NULL"
 	res, err := db.Exec(query, id)
 	if err != nil {
-		if strings.Contains(err.Error(), "a foreign key constraint fails") {
-			return fmt.Errorf("cannot delete conference without first deleting all conference data")
-		}
 		return fmt.Errorf("failed to delete conference: %w", err)
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
@@ -2,6 +2,7 @@ package model
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"time"
@@ -22,8 +23,6 @@ func NewDB(dsn string) *sqlx.DB {
 	db.SetConnMaxIdleTime(15 * time.Minute)
 
 	log.Println("connected to database")
-	// TODO(jhobbs): Init db here or in main?
-	InitDatabase(db)
 	return db
 }
 
@@ -50,8 +49,33 @@ func ping(ctx context.Context, db *sqlx.DB, deadline time.Time) {
 	}
 }
 
-func transact(db *sqlx.DB, do func(tx *sqlx.Tx) error) error {
-	tx, err := db.Beginx()
+// DB is a database or a transaction. Functions that take a DB can be
+// called within a transaction started by Transact.
+type DB interface {
+	sqlx.Ext
+	Get(dest interface{}, query string, args ...interface{}) error
+	Select(dest interface{}, query string, args ...interface{}) error
+	NamedExec(query string, arg interface{}) (sql.Result, error)
+}
+
+var (
+	_ DB = (*sqlx.DB)(nil)
+	_ DB = (*sqlx.Tx)(nil)
+)
+
+// Transact calls do within a transaction, which is committed if do
+// succeeds and rolled back otherwise.
+func Transact(db *sqlx.DB, do func(tx *sqlx.Tx) error) error {
+	return transact(db, do)
+}
+
+// transact is like Transact, but if db is already a transaction, do
+// runs as part of it.
+func transact(db DB, do func(tx *sqlx.Tx) error) error {
+	if tx, ok := db.(*sqlx.Tx); ok {
+		return do(tx)
+	}
+	tx, err := db.(*sqlx.DB).Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
@@ -62,118 +86,12 @@ func transact(db *sqlx.DB, do func(tx *sqlx.Tx) error) error {
 	return tx.Commit()
 }
 
+// InitDatabase brings the database's schema up to date by applying any
+// pending migrations. See migrate.go.
 func InitDatabase(db *sqlx.DB) {
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS conferences (
-	id INTEGER PRIMARY KEY AUTO_INCREMENT,
-	name VARCHAR(80) NOT NULL,
-    start_date DATETIME NOT NULL,
-    end_date DATETIME NOT NULL
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS users (
-	id INTEGER PRIMARY KEY AUTO_INCREMENT,
-	conference_id INTEGER,
-	name VARCHAR(200) NOT NULL DEFAULT '',
-    email VARCHAR(200) NOT NULL DEFAULT '',
-    device_id VARCHAR(200),
-    device_name VARCHAR(200),
-    platform VARCHAR(60),
-    timestamp TIMESTAMP NOT NULL,
-    expo_push_token VARCHAR(60) DEFAULT NULL,
-    FOREIGN KEY (conference_id) REFERENCES conferences(id),
-    UNIQUE (device_id)
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS locations (
-	id INTEGER PRIMARY KEY AUTO_INCREMENT,
-	name VARCHAR(200) NOT NULL,
-    place_id VARCHAR(200),
-    address VARCHAR(200) NOT NULL,
-    city VARCHAR(100) NOT NULL,
-    lat FLOAT(10,6),
-    lng FLOAT(10,6)
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS events (
-	id INTEGER PRIMARY KEY AUTO_INCREMENT,
-	conference_id INTEGER,
-	name VARCHAR(200),
-    description TEXT,
-    start_time DATETIME NOT NULL,
-    length INTEGER NOT NULL,
-    location_id INTEGER,
-    image_url VARCHAR(128),
-    key_event TINYINT NOT NULL DEFAULT '0',
-    breakout_session TINYINT NOT NULL DEFAULT '0',
-    FOREIGN KEY (conference_id) REFERENCES conferences(id),
-    FOREIGN KEY (location_id) REFERENCES locations(id)
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS rsvp (
-	event_id INTEGER NOT NULL,
-	user_id INTEGER NOT NULL,
-	attending TINYINT NOT NULL DEFAULT '0',
-	timestamp TIMESTAMP NOT NULL,
-    PRIMARY KEY (event_id, user_id),
-    FOREIGN KEY (event_id) REFERENCES events(id),
-    FOREIGN KEY (user_id) REFERENCES users(id)
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS info (
-	id INTEGER PRIMARY KEY AUTO_INCREMENT,
-	title VARCHAR(200) NOT NULL,
-    subtitle VARCHAR(200) NOT NULL,
-    content TEXT,
-    icon VARCHAR(30),
-    display_order INTEGER NOT NULL,
-    image_url VARCHAR(128),
-    key_info TINYINT NOT NULL DEFAULT '0'
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS announcements (
-	id INTEGER PRIMARY KEY AUTO_INCREMENT,
-	conference_id INTEGER,
-	title VARCHAR(200) NOT NULL,
-    message TEXT NOT NULL,
-    long_message TEXT NOT NULL,
-    icon VARCHAR(30) NOT NULL,
-    url VARCHAR(512) NOT NULL,
-	url_text VARCHAR(100) NOT NULL,
-    created_by VARCHAR(100) NOT NULL,
-    send_time DATETIME,
-    sent TINYINT NOT NULL DEFAULT '0',
-    FOREIGN KEY (conference_id) REFERENCES conferences(id)
-)
-`)
-
-	db.MustExec(`
-CREATE TABLE IF NOT EXISTS notifications (
-	user_id INTEGER,
-	announcement_id INTEGER,
-	status VARCHAR(60),
-    lease_expiration BIGINT NOT NULL DEFAULT 0,
-    receipt VARCHAR(60),
-    receipt_status VARCHAR(60),
-    timestamp TIMESTAMP DEFAULT NOW(),
-	PRIMARY KEY (user_id, announcement_id),
-	FOREIGN KEY (user_id) REFERENCES users(id),
-    FOREIGN KEY (announcement_id) REFERENCES announcements(id)
-)
-`)
-
+	if err := Migrate(db); err != nil {
+		log.Fatalf("failed to migrate database: %v", err)
+	}
 }
 
 // WipeDatabase drops all tables in the database.
@@ -183,13 +101,18 @@ func WipeDatabase(db *sqlx.DB, flagProd bool) {
 	}
 	db.MustExec(`DROP TABLE IF EXISTS rsvp`)
 	db.MustExec(`DROP TABLE IF EXISTS notifications`)
+	db.MustExec(`DROP TABLE IF EXISTS announcement_revisions`)
+	db.MustExec(`DROP TABLE IF EXISTS announcement_target_users`)
+	db.MustExec(`DROP TABLE IF EXISTS announcements`)
 	db.MustExec(`DROP TABLE IF EXISTS users`)
 	db.MustExec(`DROP TABLE IF EXISTS events`)
 	db.MustExec(`DROP TABLE IF EXISTS images`)
 	db.MustExec(`DROP TABLE IF EXISTS locations`)
 	db.MustExec(`DROP TABLE IF EXISTS info`)
-	db.MustExec(`DROP TABLE IF EXISTS announcements`)
+	db.MustExec(`DROP TABLE IF EXISTS admins`)
+	db.MustExec(`DROP TABLE IF EXISTS audit_log`)
 	db.MustExec(`DROP TABLE IF EXISTS conferences`)
+	db.MustExec(`DROP TABLE IF EXISTS schema_migrations`)
 }
 
 func InsertMockData(db *sqlx.DB, flagProd bool) {
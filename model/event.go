@@ -1,10 +1,12 @@
 package model
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -14,28 +16,28 @@ type Event struct {
 	ConferenceID    int            `db:"conference_id"`
 	Name            string         `db:"name"`
 	Description     string         `db:"description"`
-	StartTime       string         `db:"start_time"`
+	StartTime       time.Time      `db:"start_time"`
 	Length          int            `db:"length"`
 	KeyEvent        bool           `db:"key_event"`
 	BreakoutSession bool           `db:"breakout_session"`
 	LocationID      int            `db:"location_id"`
 	ImageURL        sql.NullString `db:"image_url"`
+	// ReminderMinutes overrides the conference's reminder_minutes for
+	// this event if set.
+	ReminderMinutes sql.NullInt64 `db:"reminder_minutes"`
+	// DeletedAt is set while the event is in the trash.
+	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
 type EventOptions struct {
-	ConvertTimeToUSPacific bool
-	ConferenceId           int
+	ConferenceId int
 }
 
 func ListEvents(db *sqlx.DB, options EventOptions) ([]Event, error) {
-	timeQuery := "start_time"
-	if options.ConvertTimeToUSPacific {
-		timeQuery = `DATE_FORMAT(CONVERT_TZ(start_time, 'UTC','US/Pacific'), "%a, %b %e, %Y at %l:%i %p") as start_time`
-	}
-	whereClause := `WHERE conference_id = ` + strconv.Itoa(options.ConferenceId)
+	whereClause := `WHERE conference_id = ` + strconv.Itoa(options.ConferenceId) + ` AND deleted_at IS NULL`
 
 	// TODO(jhobbs): Join the Location table to provide full Location information.
-	query := `SELECT id, conference_id, name, description, ` + timeQuery + `, length, key_event, breakout_session, location_id, image_url
+	query := `SELECT id, conference_id, name, description, start_time, length, key_event, breakout_session, location_id, image_url, reminder_minutes
 FROM events ` + whereClause + `
 ORDER BY events.start_time asc
 `
@@ -50,9 +52,9 @@ ORDER BY events.start_time asc
 	return events, nil
 }
 
-func GetEventByID(db *sqlx.DB, id string) (Event, error) {
+func GetEventByID(db DB, id string) (Event, error) {
 	const query = `
-SELECT id, conference_id, name, description, start_time, length, key_event, breakout_session, location_id, image_url
+SELECT id, conference_id, name, description, start_time, length, key_event, breakout_session, location_id, image_url, reminder_minutes, deleted_at
 FROM events
 WHERE id = ?
 `
@@ -66,29 +68,86 @@ WHERE id = ?
 	return events[0], nil
 }
 
-func SaveEvent(db *sqlx.DB, event Event) error {
+// EventListing is an event as the app lists it.
+type EventListing struct {
+	Event
+	Location struct {
+		Name    string          `db:"name"`
+		PlaceID sql.NullString  `db:"place_id"`
+		Address string          `db:"address"`
+		City    string          `db:"city"`
+		Lat     sql.NullFloat64 `db:"lat"`
+		Lng     sql.NullFloat64 `db:"lng"`
+	} `db:"location"`
+	// TotalAttendees counts the users attending the event, and
+	// Attending reports whether the user it was listed for is one of
+	// them.
+	TotalAttendees int  `db:"total_attendees"`
+	Attending      bool `db:"attending"`
+}
+
+// ListEventListings returns the events of the conference in the order
+// they start, as listed for userID, which is 0 for anonymous users. It
+// returns no events if the conference is in the trash.
+func ListEventListings(ctx context.Context, db *sqlx.DB, conferenceID, userID int) ([]EventListing, error) {
+	// Events' names and descriptions may be null, though the admin
+	// site always sets them. Locations' coordinates are
+	// single-precision FLOATs with six decimal places, so they're
+	// rounded to those places to leave out the noise from converting
+	// them to float64.
+	const query = `
+SELECT e.id, e.conference_id, COALESCE(e.name, '') AS name, COALESCE(e.description, '') AS description, e.start_time, e.length, e.key_event, e.breakout_session,
+       e.location_id, e.image_url, e.reminder_minutes,
+       l.name AS "location.name", l.place_id AS "location.place_id", l.address AS "location.address",
+       l.city AS "location.city", ROUND(l.lat, 6) AS "location.lat", ROUND(l.lng, 6) AS "location.lng",
+       (SELECT COUNT(*) FROM rsvp WHERE rsvp.event_id = e.id AND rsvp.attending) AS total_attendees,
+       EXISTS (SELECT 1 FROM rsvp WHERE rsvp.event_id = e.id AND rsvp.user_id = ? AND rsvp.attending) AS attending
+FROM events e
+JOIN locations l ON l.id = e.location_id
+JOIN conferences c ON c.id = e.conference_id
+WHERE e.conference_id = ? AND e.deleted_at IS NULL AND c.deleted_at IS NULL
+ORDER BY e.start_time, e.id
+`
+	var events []EventListing
+	if err := db.SelectContext(ctx, &events, query, userID, conferenceID); err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	if events == nil {
+		events = make([]EventListing, 0)
+	}
+	return events, nil
+}
+
+// SaveEvent inserts or updates the event, and returns its ID.
+func SaveEvent(db DB, event Event) (int, error) {
 	if event.ID == 0 {
 		return insertEvent(db, event)
 	}
-	return updateEvent(db, event)
+	return event.ID, updateEvent(db, event)
 }
 
-func insertEvent(db *sqlx.DB, event Event) error {
+func insertEvent(db DB, event Event) (int, error) {
 	query := `
-INSERT INTO events (conference_id, name, description, start_time, length, key_event, breakout_session, location_id, image_url)
-VALUES (:conference_id, TRIM(:name), TRIM(:description), :start_time, :length, :key_event, :breakout_session, :location_id, :image_url)
+INSERT INTO events (conference_id, name, description, start_time, length, key_event, breakout_session, location_id, image_url, reminder_minutes)
+VALUES (:conference_id, TRIM(:name), TRIM(:description), :start_time, :length, :key_event, :breakout_session, :location_id, :image_url, :reminder_minutes)
 `
-	if _, err := db.NamedExec(query, event); err != nil {
-		return fmt.Errorf("failed to insert event: %w", err)
+	res, err := db.NamedExec(query, event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert event: %w", err)
 	}
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inserted event id: %w", err)
+	}
+	return int(id), nil
 }
 
-func updateEvent(db *sqlx.DB, event Event) error {
+func updateEvent(db DB, event Event) error {
 	query := `
 UPDATE events
 SET conference_id = :conference_id, name = TRIM(:name), description = TRIM(:description), start_time = :start_time, length = :length,
-    key_event = :key_event, breakout_session = :breakout_session, location_id = :location_id, image_url = :image_url
+    key_event = :key_event, breakout_session = :breakout_session, location_id = :location_id, image_url = :image_url,
+    reminder_minutes = :reminder_minutes
 WHERE id = :id
 `
 	if _, err := db.NamedExec(query, event); err != nil {
@@ -97,11 +156,13 @@ WHERE id = :id
 	return nil
 }
 
-func DeleteEvent(db *sqlx.DB, id string) error {
+// DeleteEvent moves the event to the trash. Its RSVPs are kept in
+// case it is restored.
+func DeleteEvent(db DB, id string) error {
 	if id == "" {
 		return errors.New("event id must be provided")
 	}
-	const query = "DELETE FROM events WHERE id = ?"
+	const query = "UPDATE events SET deleted_at = UTC_TIMESTAMP() WHERE id = ? AND deleted_at IS NULL"
 	res, err := db.Exec(query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete event: %w", err)
@@ -17,10 +17,12 @@ type Info struct {
 	DisplayOrder int            `db:"display_order"`
 	ImageURL     sql.NullString `db:"image_url"`
 	KeyInfo      bool           `db:"key_info"`
+	// DeletedAt is set while the info is in the trash.
+	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
 func ListInfo(db *sqlx.DB) ([]Info, error) {
-	const query = "SELECT id, title, subtitle, content, icon, display_order FROM info ORDER BY display_order"
+	const query = "SELECT id, title, subtitle, content, icon, display_order, image_url, key_info FROM info WHERE deleted_at IS NULL ORDER BY display_order, id"
 	var info []Info
 	if err := db.Select(&info, query); err != nil {
 		return info, fmt.Errorf("failed to list info: %w", err)
@@ -31,9 +33,9 @@ func ListInfo(db *sqlx.DB) ([]Info, error) {
 	return info, nil
 }
 
-func GetInfoByID(db *sqlx.DB, id string) (Info, error) {
+func GetInfoByID(db DB, id string) (Info, error) {
 	const query = `
-SELECT id, title, subtitle, content, icon, display_order, image_url, key_info
+SELECT id, title, subtitle, content, icon, display_order, image_url, key_info, deleted_at
 FROM info
 WHERE id = ?
 `
@@ -47,25 +49,31 @@ WHERE id = ?
 	return info[0], nil
 }
 
-func SaveInfo(db *sqlx.DB, info Info) error {
+// SaveInfo inserts or updates the info, and returns its ID.
+func SaveInfo(db DB, info Info) (int, error) {
 	if info.ID == 0 {
 		return insertInfo(db, info)
 	}
-	return updateInfo(db, info)
+	return info.ID, updateInfo(db, info)
 }
 
-func insertInfo(db *sqlx.DB, info Info) error {
+func insertInfo(db DB, info Info) (int, error) {
 	query := `
 INSERT INTO info (title, subtitle, content, icon, display_order, image_url, key_info)
 VALUES (TRIM(:title), TRIM(:subtitle), TRIM(:content), :icon, :display_order, :image_url, :key_info)
 `
-	if _, err := db.NamedExec(query, info); err != nil {
-		return fmt.Errorf("failed to insert info: %w", err)
+	res, err := db.NamedExec(query, info)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert info: %w", err)
 	}
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inserted info id: %w", err)
+	}
+	return int(id), nil
 }
 
-func updateInfo(db *sqlx.DB, info Info) error {
+func updateInfo(db DB, info Info) error {
 	query := `
 UPDATE info
 SET title = TRIM(:title), subtitle = TRIM(:subtitle), content = TRIM(:content), icon = :icon, display_order = :display_order, image_url = :image_url, key_info = :key_info
@@ -77,11 +85,12 @@ WHERE id = :id
 	return nil
 }
 
-func DeleteInfo(db *sqlx.DB, id string) error {
+// DeleteInfo moves the info to the trash.
+func DeleteInfo(db DB, id string) error {
 	if id == "" {
 		return errors.New("info id must be provided")
 	}
-	const query = "DELETE FROM info WHERE id = ?"
+	const query = "UPDATE info SET deleted_at = UTC_TIMESTAMP() WHERE id = ? AND deleted_at IS NULL"
 	res, err := db.Exec(query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete info: %w", err)
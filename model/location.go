@@ -1,9 +1,9 @@
 package model
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -16,11 +16,14 @@ type Location struct {
 	City    string  `db:"city"`
 	Lat     float64 `db:"lat"`
 	Lng     float64 `db:"lng"`
+	// DeletedAt is set while the location is in the trash.
+	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
 func ListLocations(db *sqlx.DB) ([]Location, error) {
 	const query = `
 SELECT id, name, place_id, address, city, lat, lng FROM locations
+WHERE deleted_at IS NULL
 ORDER BY name asc
 `
 	var locations []Location
@@ -33,9 +36,9 @@ ORDER BY name asc
 	return locations, nil
 }
 
-func GetLocationByID(db *sqlx.DB, id string) (Location, error) {
+func GetLocationByID(db DB, id string) (Location, error) {
 	const query = `
-SELECT id, name, place_id, address, city, lat, lng
+SELECT id, name, place_id, address, city, lat, lng, deleted_at
 FROM locations
 WHERE id = ?
 `
@@ -49,25 +52,31 @@ WHERE id = ?
 	return locations[0], nil
 }
 
-func SaveLocation(db *sqlx.DB, location Location) error {
+// SaveLocation inserts or updates the location, and returns its ID.
+func SaveLocation(db DB, location Location) (int, error) {
 	if location.ID == 0 {
 		return insertLocation(db, location)
 	}
-	return updateLocation(db, location)
+	return location.ID, updateLocation(db, location)
 }
 
-func insertLocation(db *sqlx.DB, location Location) error {
+func insertLocation(db DB, location Location) (int, error) {
 	query := `
 INSERT INTO locations (name, place_id, address, city, lat, lng)
 VALUES (TRIM(:name), TRIM(:place_id), TRIM(:address), TRIM(:city), :lat, :lng)
 `
-	if _, err := db.NamedExec(query, location); err != nil {
-		return fmt.Errorf("failed to insert location: %w", err)
+	res, err := db.NamedExec(query, location)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert location: %w", err)
 	}
-	return nil
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get inserted location id: %w", err)
+	}
+	return int(id), nil
 }
 
-func updateLocation(db *sqlx.DB, location Location) error {
+func updateLocation(db DB, location Location) error {
 	query := `
 UPDATE locations
 SET name = TRIM(:name), place_id = TRIM(:place_id), address = TRIM(:address), city = TRIM(:city), lat = :lat, lng = :lng
@@ -79,16 +88,22 @@ WHERE id = :id
 	return nil
 }
 
-func DeleteLocation(db *sqlx.DB, id string) error {
+// DeleteLocation moves the location to the trash. Locations that
+// events outside the trash are held at can't be deleted.
+func DeleteLocation(db DB, id string) error {
 	if id == "" {
 		return errors.New("location id must be provided")
 	}
-	const query = "DELETE FROM locations WHERE id = ?"
+	var events int
+	if err := db.Get(&events, "SELECT COUNT(*) FROM events WHERE location_id = ? AND deleted_at IS NULL", id); err != nil {
+		return fmt.Errorf("failed to count location events: %w", err)
+	}
+	if events > 0 {
+		return fmt.Errorf("cannot delete location because it is used by events")
+	}
+	const query = "UPDATE locations SET deleted_at = UTC_TIMESTAMP() WHERE id = ? AND deleted_at IS NULL"
 	res, err := db.Exec(query, id)
 	if err != nil {
-		if strings.Contains(err.Error(), "a foreign key constraint fails") {
-			return fmt.Errorf("cannot delete location because it is used by events")
-		}
 		return fmt.Errorf("failed to delete location: %w", err)
 	}
 	if rows, _ := res.RowsAffected(); rows == 0 {
@@ -0,0 +1,257 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Schema changes are made by adding a pair of scripts to the
+// migrations directory: NNNN_name.up.sql makes the change, and
+// NNNN_name.down.sql reverts it. Migrations are applied in order of
+// their version NNNN, and each is recorded in schema_migrations once
+// it has been applied. Statements in a script are separated by
+// semicolons at the ends of lines, and lines starting with "--" are
+// comments.
+//
+// MySQL commits schema changes immediately, so a migration that fails
+// partway through may need fixing up by hand before it is retried.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the MySQL named lock held while migrating, so that
+// replicas starting at the same time don't migrate concurrently.
+const migrationLock = "alc-mobile-api-migrations"
+
+// migrationLockTimeout is how long to wait for another process to
+// finish migrating, in seconds.
+const migrationLockTimeout = 300
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migrations returns the embedded migrations, ordered by version.
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		script, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration: %w", err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names, %q and %q", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%v needs both an up and a down script", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationStatements splits a migration script into its statements.
+func migrationStatements(script string) []string {
+	var statements []string
+	var statement strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		statement.WriteString(line)
+		statement.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(statement.String()), ";"))
+			statement.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(statement.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// MigrationStatus reports which migrations have been applied.
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// GetMigrationStatus returns the status of every migration, ordered by
+// version.
+func GetMigrationStatus(db *sqlx.DB) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := withMigrationLock(db, func(conn *sql.Conn) error {
+		migrations, applied, err := loadMigrationState(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			status = append(status, MigrationStatus{Migration: m, Applied: applied[m.Version]})
+		}
+		return nil
+	})
+	return status, err
+}
+
+// Migrate applies the migrations that haven't been applied yet.
+func Migrate(db *sqlx.DB) error {
+	return withMigrationLock(db, func(conn *sql.Conn) error {
+		migrations, applied, err := loadMigrationState(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if applied[m.Version] {
+				continue
+			}
+			log.Printf("Applying migration %d_%v.\n", m.Version, m.Name)
+			if err := execMigration(conn, m.Up); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%v: %w", m.Version, m.Name, err)
+			}
+			if _, err := conn.ExecContext(context.Background(), "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+				return fmt.Errorf("failed to record migration %d_%v: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the most recently applied steps migrations.
+func MigrateDown(db *sqlx.DB, steps int) error {
+	return withMigrationLock(db, func(conn *sql.Conn) error {
+		migrations, applied, err := loadMigrationState(conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if !applied[m.Version] {
+				continue
+			}
+			log.Printf("Reverting migration %d_%v.\n", m.Version, m.Name)
+			if err := execMigration(conn, m.Down); err != nil {
+				return fmt.Errorf("failed to revert migration %d_%v: %w", m.Version, m.Name, err)
+			}
+			if _, err := conn.ExecContext(context.Background(), "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+				return fmt.Errorf("failed to record reverting migration %d_%v: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func execMigration(conn *sql.Conn, script string) error {
+	for _, statement := range migrationStatements(script) {
+		if _, err := conn.ExecContext(context.Background(), statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMigrationState returns the embedded migrations, and which of
+// them have been applied.
+func loadMigrationState(conn *sql.Conn) ([]Migration, map[int]bool, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name VARCHAR(200) NOT NULL,
+	applied_at TIMESTAMP DEFAULT NOW()
+)
+`); err != nil {
+		return nil, nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select applied migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, nil, fmt.Errorf("failed to select applied migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to select applied migrations: %w", err)
+	}
+	return migrations, applied, nil
+}
+
+// withMigrationLock calls do with a connection holding the migration
+// lock. MySQL named locks belong to a connection, so everything done
+// under the lock has to use that connection.
+func withMigrationLock(db *sqlx.DB, do func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for migrating: %w", err)
+	}
+	defer conn.Close()
+
+	var acquired sql.NullBool
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLock, migrationLockTimeout).Scan(&acquired); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if !acquired.Bool {
+		return fmt.Errorf("timed out waiting for migration lock")
+	}
+	defer conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", migrationLock)
+
+	return do(conn)
+}
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS notifications;
+DROP TABLE IF EXISTS announcements;
+DROP TABLE IF EXISTS info;
+DROP TABLE IF EXISTS rsvp;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS locations;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS conferences;
@@ -0,0 +1,99 @@
+-- The schema as of the introduction of migrations, as InitDatabase
+-- used to create it. Tables are only created if they don't exist, so
+-- that databases set up before then adopt this migration as already
+-- applied, and are brought up to date by the migrations after it.
+
+CREATE TABLE IF NOT EXISTS conferences (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	name VARCHAR(80) NOT NULL,
+	start_date DATETIME NOT NULL,
+	end_date DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	conference_id INTEGER,
+	name VARCHAR(200) NOT NULL DEFAULT '',
+	email VARCHAR(200) NOT NULL DEFAULT '',
+	device_id VARCHAR(200),
+	device_name VARCHAR(200),
+	platform VARCHAR(60),
+	timestamp TIMESTAMP NOT NULL,
+	expo_push_token VARCHAR(60) DEFAULT NULL,
+	FOREIGN KEY (conference_id) REFERENCES conferences(id),
+	UNIQUE (device_id)
+);
+
+CREATE TABLE IF NOT EXISTS locations (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	name VARCHAR(200) NOT NULL,
+	place_id VARCHAR(200),
+	address VARCHAR(200) NOT NULL,
+	city VARCHAR(100) NOT NULL,
+	lat FLOAT(10,6),
+	lng FLOAT(10,6)
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	conference_id INTEGER,
+	name VARCHAR(200),
+	description TEXT,
+	start_time DATETIME NOT NULL,
+	length INTEGER NOT NULL,
+	location_id INTEGER,
+	image_url VARCHAR(128),
+	key_event TINYINT NOT NULL DEFAULT '0',
+	breakout_session TINYINT NOT NULL DEFAULT '0',
+	FOREIGN KEY (conference_id) REFERENCES conferences(id),
+	FOREIGN KEY (location_id) REFERENCES locations(id)
+);
+
+CREATE TABLE IF NOT EXISTS rsvp (
+	event_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	attending TINYINT NOT NULL DEFAULT '0',
+	timestamp TIMESTAMP NOT NULL,
+	PRIMARY KEY (event_id, user_id),
+	FOREIGN KEY (event_id) REFERENCES events(id),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS info (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	title VARCHAR(200) NOT NULL,
+	subtitle VARCHAR(200) NOT NULL,
+	content TEXT,
+	icon VARCHAR(30),
+	display_order INTEGER NOT NULL,
+	image_url VARCHAR(128),
+	key_info TINYINT NOT NULL DEFAULT '0'
+);
+
+CREATE TABLE IF NOT EXISTS announcements (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	conference_id INTEGER,
+	title VARCHAR(200) NOT NULL,
+	message TEXT NOT NULL,
+	long_message TEXT NOT NULL,
+	icon VARCHAR(30) NOT NULL,
+	url VARCHAR(512) NOT NULL,
+	url_text VARCHAR(100) NOT NULL,
+	created_by VARCHAR(100) NOT NULL,
+	send_time DATETIME,
+	sent TINYINT NOT NULL DEFAULT '0',
+	FOREIGN KEY (conference_id) REFERENCES conferences(id)
+);
+
+CREATE TABLE IF NOT EXISTS notifications (
+	user_id INTEGER,
+	announcement_id INTEGER,
+	status VARCHAR(60),
+	lease_expiration BIGINT NOT NULL DEFAULT 0,
+	receipt VARCHAR(60),
+	receipt_status VARCHAR(60),
+	timestamp TIMESTAMP DEFAULT NOW(),
+	PRIMARY KEY (user_id, announcement_id),
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	FOREIGN KEY (announcement_id) REFERENCES announcements(id)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE notifications
+	DROP COLUMN attempts,
+	DROP COLUMN next_attempt_time,
+	DROP COLUMN last_error;
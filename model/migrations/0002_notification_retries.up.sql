@@ -0,0 +1,6 @@
+-- Failed pushes are retried with exponential backoff, up to a limit.
+
+ALTER TABLE notifications
+	ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN next_attempt_time BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN last_error VARCHAR(200);
@@ -0,0 +1,5 @@
+UPDATE users SET expo_push_token = NULL WHERE push_token_type != 'expo';
+
+ALTER TABLE users
+	DROP COLUMN push_token_type,
+	MODIFY expo_push_token VARCHAR(60) DEFAULT NULL;
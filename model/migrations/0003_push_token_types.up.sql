@@ -0,0 +1,6 @@
+-- Users may register FCM and APNs tokens, which are longer than Expo's,
+-- instead of an Expo token.
+
+ALTER TABLE users
+	MODIFY expo_push_token VARCHAR(255) DEFAULT NULL,
+	ADD COLUMN push_token_type VARCHAR(10) NOT NULL DEFAULT 'expo';
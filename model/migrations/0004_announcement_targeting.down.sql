@@ -0,0 +1,9 @@
+DROP TABLE announcement_target_users;
+
+ALTER TABLE announcements
+	DROP FOREIGN KEY announcements_target_event_fk;
+
+ALTER TABLE announcements
+	DROP COLUMN target_event_id,
+	DROP COLUMN target_platform,
+	DROP COLUMN target_registered_after;
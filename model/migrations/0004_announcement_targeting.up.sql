@@ -0,0 +1,16 @@
+-- Announcements may be sent to the attendees of an event, the users of
+-- a platform, users who registered after a time, or chosen users.
+
+ALTER TABLE announcements
+	ADD COLUMN target_event_id INTEGER,
+	ADD COLUMN target_platform VARCHAR(60) NOT NULL DEFAULT '',
+	ADD COLUMN target_registered_after DATETIME,
+	ADD CONSTRAINT announcements_target_event_fk FOREIGN KEY (target_event_id) REFERENCES events(id);
+
+CREATE TABLE announcement_target_users (
+	announcement_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	PRIMARY KEY (announcement_id, user_id),
+	FOREIGN KEY (announcement_id) REFERENCES announcements(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
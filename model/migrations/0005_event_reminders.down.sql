@@ -0,0 +1,21 @@
+DELETE FROM notifications WHERE announcement_id IS NULL;
+
+ALTER TABLE notifications
+	DROP FOREIGN KEY notifications_event_fk;
+
+ALTER TABLE notifications
+	DROP INDEX notifications_user_event,
+	DROP INDEX notifications_user_announcement,
+	DROP COLUMN event_id,
+	DROP COLUMN id,
+	MODIFY announcement_id INTEGER NOT NULL,
+	ADD PRIMARY KEY (user_id, announcement_id);
+
+ALTER TABLE events
+	DROP COLUMN reminder_minutes;
+
+ALTER TABLE users
+	DROP COLUMN event_reminders;
+
+ALTER TABLE conferences
+	DROP COLUMN reminder_minutes;
@@ -0,0 +1,24 @@
+-- Attendees are reminded of events shortly before they start. Reminders
+-- are notifications for an event rather than an announcement, so
+-- notifications get their own ID instead of being keyed by user and
+-- announcement.
+
+ALTER TABLE conferences
+	ADD COLUMN reminder_minutes INTEGER NOT NULL DEFAULT 15;
+
+ALTER TABLE users
+	ADD COLUMN event_reminders TINYINT NOT NULL DEFAULT '1';
+
+ALTER TABLE events
+	ADD COLUMN reminder_minutes INTEGER;
+
+ALTER TABLE notifications
+	DROP PRIMARY KEY,
+	ADD COLUMN id INTEGER PRIMARY KEY AUTO_INCREMENT FIRST,
+	MODIFY announcement_id INTEGER,
+	ADD COLUMN event_id INTEGER,
+	ADD UNIQUE notifications_user_announcement (user_id, announcement_id),
+	ADD UNIQUE notifications_user_event (user_id, event_id);
+
+ALTER TABLE notifications
+	ADD CONSTRAINT notifications_event_fk FOREIGN KEY (event_id) REFERENCES events(id);
@@ -0,0 +1,12 @@
+ALTER TABLE announcements
+	DROP FOREIGN KEY announcements_link_event_fk,
+	DROP FOREIGN KEY announcements_link_info_fk;
+
+ALTER TABLE announcements
+	DROP COLUMN link_event_id,
+	DROP COLUMN link_info_id,
+	DROP COLUMN badge,
+	DROP COLUMN sound,
+	DROP COLUMN channel_id,
+	DROP COLUMN priority,
+	DROP COLUMN ttl_seconds;
@@ -0,0 +1,13 @@
+-- Pushes carry a deep link and the sound, badge, channel, priority and
+-- time to live to deliver them with.
+
+ALTER TABLE announcements
+	ADD COLUMN link_event_id INTEGER,
+	ADD COLUMN link_info_id INTEGER,
+	ADD COLUMN badge INTEGER,
+	ADD COLUMN sound VARCHAR(30) NOT NULL DEFAULT 'default',
+	ADD COLUMN channel_id VARCHAR(60) NOT NULL DEFAULT '',
+	ADD COLUMN priority VARCHAR(10) NOT NULL DEFAULT 'default',
+	ADD COLUMN ttl_seconds INTEGER NOT NULL DEFAULT 0,
+	ADD CONSTRAINT announcements_link_event_fk FOREIGN KEY (link_event_id) REFERENCES events(id),
+	ADD CONSTRAINT announcements_link_info_fk FOREIGN KEY (link_info_id) REFERENCES info(id);
@@ -0,0 +1,13 @@
+DELETE FROM notifications WHERE revision_id IS NOT NULL;
+
+ALTER TABLE notifications
+	DROP FOREIGN KEY notifications_revision_fk;
+
+ALTER TABLE notifications
+	DROP INDEX notifications_user_revision,
+	DROP COLUMN revision_id;
+
+DROP TABLE announcement_revisions;
+
+ALTER TABLE announcements
+	DROP COLUMN retracted;
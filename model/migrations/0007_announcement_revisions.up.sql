@@ -0,0 +1,25 @@
+-- Edits to sent announcements and their retraction are kept as
+-- revisions, which users are notified of.
+
+ALTER TABLE announcements
+	ADD COLUMN retracted TINYINT NOT NULL DEFAULT '0';
+
+CREATE TABLE announcement_revisions (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	announcement_id INTEGER NOT NULL,
+	action VARCHAR(10) NOT NULL,
+	title VARCHAR(200) NOT NULL,
+	message TEXT NOT NULL,
+	long_message TEXT NOT NULL,
+	url VARCHAR(512) NOT NULL,
+	url_text VARCHAR(100) NOT NULL,
+	notice VARCHAR(240) NOT NULL DEFAULT '',
+	created_by VARCHAR(100) NOT NULL,
+	timestamp TIMESTAMP DEFAULT NOW(),
+	FOREIGN KEY (announcement_id) REFERENCES announcements(id) ON DELETE CASCADE
+);
+
+ALTER TABLE notifications
+	ADD COLUMN revision_id INTEGER,
+	ADD UNIQUE notifications_user_revision (user_id, revision_id),
+	ADD CONSTRAINT notifications_revision_fk FOREIGN KEY (revision_id) REFERENCES announcement_revisions(id);
@@ -0,0 +1,3 @@
+ALTER TABLE announcements
+	DROP COLUMN expires_at,
+	DROP COLUMN pinned;
@@ -0,0 +1,6 @@
+-- Announcements may expire from the app's list, or be pinned to its
+-- top.
+
+ALTER TABLE announcements
+	ADD COLUMN expires_at DATETIME,
+	ADD COLUMN pinned TINYINT NOT NULL DEFAULT '0';
@@ -0,0 +1,4 @@
+ALTER TABLE announcements
+	DROP COLUMN state,
+	DROP COLUMN approved_by,
+	DROP COLUMN approved_time;
@@ -0,0 +1,11 @@
+-- Announcements are only sent once a second admin approves them.
+-- Existing announcements were scheduled when no approval was needed,
+-- so they are counted as approved, and those not sent yet still go
+-- out at their send time.
+
+ALTER TABLE announcements
+	ADD COLUMN state VARCHAR(10) NOT NULL DEFAULT 'draft',
+	ADD COLUMN approved_by VARCHAR(100),
+	ADD COLUMN approved_time DATETIME;
+
+UPDATE announcements SET state = 'approved';
@@ -0,0 +1,8 @@
+ALTER TABLE announcements
+	DROP COLUMN urgent;
+
+ALTER TABLE users
+	DROP COLUMN muted_announcement_icons,
+	DROP COLUMN quiet_hours_start,
+	DROP COLUMN quiet_hours_end,
+	DROP COLUMN timezone;
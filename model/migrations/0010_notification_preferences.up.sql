@@ -0,0 +1,11 @@
+-- Users may opt out of categories of notifications and set quiet hours
+-- in their time zone, which only urgent announcements break.
+
+ALTER TABLE users
+	ADD COLUMN muted_announcement_icons JSON,
+	ADD COLUMN quiet_hours_start TIME,
+	ADD COLUMN quiet_hours_end TIME,
+	ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE announcements
+	ADD COLUMN urgent TINYINT NOT NULL DEFAULT '0';
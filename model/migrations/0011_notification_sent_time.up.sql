@@ -0,0 +1,4 @@
+-- The delivery dashboard shows how long pushes took to send.
+
+ALTER TABLE notifications
+	ADD COLUMN sent_time BIGINT;
@@ -0,0 +1,6 @@
+-- Public API requests are authenticated with signed device tokens.
+-- Once a user's token has been used, their device ID alone no longer
+-- identifies them.
+
+ALTER TABLE users
+	ADD COLUMN device_token_used TINYINT NOT NULL DEFAULT '0';
@@ -0,0 +1,13 @@
+-- Admins are granted roles, for one conference or (with a NULL
+-- conference_id) for all of them.
+
+CREATE TABLE admins (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	email VARCHAR(200) NOT NULL,
+	conference_id INTEGER,
+	role VARCHAR(10) NOT NULL,
+	invited_by VARCHAR(200) NOT NULL DEFAULT '',
+	timestamp TIMESTAMP DEFAULT NOW(),
+	INDEX (email),
+	FOREIGN KEY (conference_id) REFERENCES conferences(id) ON DELETE CASCADE
+);
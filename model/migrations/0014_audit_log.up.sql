@@ -0,0 +1,13 @@
+-- Changes made from the admin site are recorded in an audit log.
+
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTO_INCREMENT,
+	actor VARCHAR(200) NOT NULL,
+	entity_type VARCHAR(20) NOT NULL,
+	entity_id INTEGER NOT NULL,
+	action VARCHAR(20) NOT NULL,
+	changes JSON NOT NULL,
+	timestamp TIMESTAMP DEFAULT NOW(),
+	INDEX (entity_type, entity_id),
+	INDEX (actor)
+);
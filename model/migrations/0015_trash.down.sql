@@ -0,0 +1,16 @@
+-- Content in the trash is restored, rather than lost.
+
+ALTER TABLE announcements
+	DROP COLUMN deleted_at;
+
+ALTER TABLE info
+	DROP COLUMN deleted_at;
+
+ALTER TABLE events
+	DROP COLUMN deleted_at;
+
+ALTER TABLE locations
+	DROP COLUMN deleted_at;
+
+ALTER TABLE conferences
+	DROP COLUMN deleted_at;
@@ -0,0 +1,17 @@
+-- Deleted content is kept in a trash, from which it can be restored,
+-- until it is purged.
+
+ALTER TABLE conferences
+	ADD COLUMN deleted_at DATETIME;
+
+ALTER TABLE locations
+	ADD COLUMN deleted_at DATETIME;
+
+ALTER TABLE events
+	ADD COLUMN deleted_at DATETIME;
+
+ALTER TABLE info
+	ADD COLUMN deleted_at DATETIME;
+
+ALTER TABLE announcements
+	ADD COLUMN deleted_at DATETIME;
@@ -0,0 +1,19 @@
+ALTER TABLE users
+	MODIFY event_reminders TINYINT NOT NULL DEFAULT '1',
+	MODIFY device_token_used TINYINT NOT NULL DEFAULT '0';
+
+ALTER TABLE events
+	MODIFY key_event TINYINT NOT NULL DEFAULT '0',
+	MODIFY breakout_session TINYINT NOT NULL DEFAULT '0';
+
+ALTER TABLE rsvp
+	MODIFY attending TINYINT NOT NULL DEFAULT '0';
+
+ALTER TABLE info
+	MODIFY key_info TINYINT NOT NULL DEFAULT '0';
+
+ALTER TABLE announcements
+	MODIFY sent TINYINT NOT NULL DEFAULT '0',
+	MODIFY urgent TINYINT NOT NULL DEFAULT '0',
+	MODIFY retracted TINYINT NOT NULL DEFAULT '0',
+	MODIFY pinned TINYINT NOT NULL DEFAULT '0';
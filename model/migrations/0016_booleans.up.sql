@@ -0,0 +1,22 @@
+-- Flags were TINYINT columns holding 0 or 1. BOOLEAN is still
+-- TINYINT(1) to MySQL, but says what the column means.
+
+ALTER TABLE users
+	MODIFY event_reminders BOOLEAN NOT NULL DEFAULT TRUE,
+	MODIFY device_token_used BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE events
+	MODIFY key_event BOOLEAN NOT NULL DEFAULT FALSE,
+	MODIFY breakout_session BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE rsvp
+	MODIFY attending BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE info
+	MODIFY key_info BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE announcements
+	MODIFY sent BOOLEAN NOT NULL DEFAULT FALSE,
+	MODIFY urgent BOOLEAN NOT NULL DEFAULT FALSE,
+	MODIFY retracted BOOLEAN NOT NULL DEFAULT FALSE,
+	MODIFY pinned BOOLEAN NOT NULL DEFAULT FALSE;
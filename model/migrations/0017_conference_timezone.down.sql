@@ -0,0 +1,2 @@
+ALTER TABLE conferences
+	DROP COLUMN timezone;
@@ -0,0 +1,5 @@
+-- Each conference's times are entered and shown in its own time zone.
+-- Until now they were all shown in US Pacific time.
+
+ALTER TABLE conferences
+	ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'America/Los_Angeles';
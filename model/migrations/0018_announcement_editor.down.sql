@@ -0,0 +1,2 @@
+ALTER TABLE announcements
+	DROP COLUMN updated_by;
@@ -0,0 +1,8 @@
+-- created_by was overwritten each time an announcement was saved, so it
+-- held whoever changed it last. It now keeps the announcement's author,
+-- and updated_by whoever changed it last.
+
+ALTER TABLE announcements
+	ADD COLUMN updated_by VARCHAR(100) NOT NULL DEFAULT '';
+
+UPDATE announcements SET updated_by = created_by;
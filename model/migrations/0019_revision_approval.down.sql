@@ -0,0 +1,5 @@
+ALTER TABLE announcement_revisions
+	DROP COLUMN state,
+	DROP COLUMN approved_by,
+	DROP COLUMN approved_time,
+	DROP COLUMN sent;
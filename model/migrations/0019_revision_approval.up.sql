@@ -0,0 +1,11 @@
+-- Notices about edited or retracted announcements are only sent once a
+-- second admin approves them, like the announcements themselves. The
+-- notices of existing revisions have already been queued.
+
+ALTER TABLE announcement_revisions
+	ADD COLUMN state VARCHAR(10) NOT NULL DEFAULT 'draft',
+	ADD COLUMN approved_by VARCHAR(100),
+	ADD COLUMN approved_time DATETIME,
+	ADD COLUMN sent BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE announcement_revisions SET state = 'approved', sent = TRUE;
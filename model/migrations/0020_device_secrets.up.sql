@@ -0,0 +1,10 @@
+-- Device tokens are signed with a random secret per user, which is
+-- replaced when the device registers again. Tokens issued before are
+-- no longer valid, so their devices are treated as never having used
+-- one, and can register for a new token while legacy device IDs are
+-- allowed.
+
+ALTER TABLE users
+	ADD COLUMN device_secret VARCHAR(64) NOT NULL DEFAULT '';
+
+UPDATE users SET device_token_used = FALSE;
@@ -2,8 +2,11 @@ package model
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strconv"
 	"time"
 
@@ -12,19 +15,108 @@ import (
 
 type Notification struct {
 	// From the notifications table.
-	ID              string `db:"id"`
-	UserID          int    `db:"user_id"`
-	AnnouncementID  int    `db:"announcement_id"`
-	Status          string `db:"status"`
-	LeaseExpiration int64  `db:"expiration_time"`
-	Receipt         string `db:"receipt"`
+	ID              int           `db:"id"`
+	UserID          int           `db:"user_id"`
+	AnnouncementID  sql.NullInt64 `db:"announcement_id"`
+	EventID         sql.NullInt64 `db:"event_id"`
+	RevisionID      sql.NullInt64 `db:"revision_id"`
+	Status          string        `db:"status"`
+	LeaseExpiration int64         `db:"expiration_time"`
+	Attempts        int           `db:"attempts"`
+	NextAttemptTime int64         `db:"next_attempt_time"`
+	LastError       string        `db:"last_error"`
+	Receipt         string        `db:"receipt"`
+	ReceiptStatus   string        `db:"receipt_status"`
 	// From joined tables.
 	ExpoPushToken string `db:"expo_push_token"`
+	PushTokenType string `db:"push_token_type"`
 	Title         string `db:"title"`
 	Body          string `db:"body"`
+	// Urgent notifications are pushed even during the user's quiet
+	// hours.
+	Urgent          bool           `db:"urgent"`
+	QuietHoursStart sql.NullString `db:"quiet_hours_start"`
+	QuietHoursEnd   sql.NullString `db:"quiet_hours_end"`
+	// Timezone is the user's time zone, or else that of the conference
+	// the notification is about.
+	Timezone string `db:"timezone"`
+	// RevisionAction is set for follow-ups to an edited or retracted
+	// announcement.
+	RevisionAction string `db:"revision_action"`
+	// Deep-link target and delivery options, from the announcement or
+	// defaults for the kind of notification.
+	LinkEventID sql.NullInt64 `db:"link_event_id"`
+	LinkInfoID  sql.NullInt64 `db:"link_info_id"`
+	Badge       sql.NullInt64 `db:"badge"`
+	Sound       string        `db:"sound"`
+	ChannelID   string        `db:"channel_id"`
+	Priority    string        `db:"priority"`
+	TTLSeconds  int           `db:"ttl_seconds"`
 }
 
+// announcementAudience returns an SQL condition that matches users who
+// are sent the announcement in the announcements row, if they have a
+// push token. targeted is a condition that matches the users that the
+// announcement is limited to, if it is limited to any.
+//
+// CountAnnouncementRecipients evaluates it against a derived row for
+// announcements that haven't been saved, so it may only use the
+// announcement's conference_id, icon, and target_* columns.
+func announcementAudience(targeted string) string {
+	return `(
+	(announcements.target_event_id IS NULL OR EXISTS (
+		SELECT 1 FROM rsvp
+		WHERE rsvp.user_id = users.id AND rsvp.event_id = announcements.target_event_id AND rsvp.attending
+	))
+	AND (announcements.target_platform = "" OR users.platform = announcements.target_platform)
+	AND (announcements.target_registered_after IS NULL OR users.timestamp >= announcements.target_registered_after)
+	AND ` + targeted + `
+	AND ` + acceptsAnnouncement + `
+)`
+}
+
+// targetedByAnnouncement matches the users in a saved announcement's
+// announcement_target_users, or all users if it has none.
+const targetedByAnnouncement = `(
+	NOT EXISTS (SELECT 1 FROM announcement_target_users t WHERE t.announcement_id = announcements.id)
+	OR EXISTS (SELECT 1 FROM announcement_target_users t WHERE t.announcement_id = announcements.id AND t.user_id = users.id)
+)`
+
+// EnqueueAnnouncementNotifications queues notifications for the
+// approved announcements that are due, and for the approved notices
+// of revisions to them.
 func EnqueueAnnouncementNotifications(db *sqlx.DB) error {
+	return transact(db, func(tx *sqlx.Tx) error {
+		if err := enqueueAnnouncements(tx); err != nil {
+			return err
+		}
+		return enqueueRevisions(tx)
+	})
+}
+
+// enqueueAnnouncements queues a notification about each approved
+// announcement that is due for each user in its audience.
+func enqueueAnnouncements(tx *sqlx.Tx) error {
+	// Lock the announcements that are due, so that each is marked
+	// as sent together with its notifications, even if nobody in
+	// its audience can be notified.
+	var ids []int
+	err := tx.Select(&ids, `
+SELECT announcements.id
+FROM announcements
+JOIN conferences ON conferences.id = announcements.conference_id
+WHERE state = "approved" AND NOT sent AND NOT retracted
+	AND announcements.deleted_at IS NULL AND conferences.deleted_at IS NULL
+	AND send_time <= UTC_TIMESTAMP
+FOR UPDATE OF announcements
+`)
+	if err != nil {
+		return fmt.Errorf("failed to select announcements to send: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
 	// Inserts unsent announcements into the notifications table.
 	// INSERT IGNORE is used so that it can run again if
 	// it is interrupted without causing any unintended side effects.
@@ -33,10 +125,15 @@ INSERT IGNORE into notifications (user_id, announcement_id, status)
 	SELECT users.id as user_id, announcements.id as announcement_id, "Queued" as status
 	FROM announcements
 	JOIN users ON users.conference_id = announcements.conference_id
-	WHERE sent = 0 AND send_time <= UTC_TIMESTAMP AND expo_push_token like "ExponentPushToken[%]"
+	WHERE announcements.id IN (?) AND ` + hasPushToken + `
+		AND ` + announcementAudience(targetedByAnnouncement) + `
 	ORDER BY send_time asc
 `
-	results, err := db.Exec(insertQuery)
+	query, args, err := sqlx.In(insertQuery, ids)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query using IN clause: %w", err)
+	}
+	results, err := tx.Exec(query, args...)
 	if err != nil {
 		return fmt.Errorf("failed to insert notifications: %w", err)
 	}
@@ -46,44 +143,187 @@ INSERT IGNORE into notifications (user_id, announcement_id, status)
 	}
 	log.Printf("Enqueued %d notifications.\n", notificationRows)
 
-	// Mark the announcement as "sent" in the announcements table.
-	updateQuery := `
-UPDATE announcements
-SET sent = 1
-WHERE id in (SELECT DISTINCT announcement_id FROM notifications) AND sent = 0
-`
-	results, err = db.Exec(updateQuery)
+	// Mark the announcements as "sent" in the announcements table.
+	query, args, err = sqlx.In(`UPDATE announcements SET sent = TRUE WHERE id IN (?)`, ids)
 	if err != nil {
+		return fmt.Errorf("failed to prepare query using IN clause: %w", err)
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
 		return fmt.Errorf("failed to mark announcement as sent: %w", err)
 	}
+	return nil
+}
+
+// enqueueRevisions queues a follow-up notification about each
+// approved notice for each user who was sent the original announcement.
+// Notices about edits aren't sent once the announcement is retracted.
+func enqueueRevisions(tx *sqlx.Tx) error {
+	var ids []int
+	err := tx.Select(&ids, `
+SELECT r.id
+FROM announcement_revisions r
+JOIN announcements ON announcements.id = r.announcement_id
+JOIN conferences ON conferences.id = announcements.conference_id
+WHERE r.state = "approved" AND NOT r.sent AND r.notice != ""
+	AND (r.action = "retract" OR NOT announcements.retracted)
+	AND announcements.deleted_at IS NULL AND conferences.deleted_at IS NULL
+FOR UPDATE OF r
+`)
+	if err != nil {
+		return fmt.Errorf("failed to select notices to send: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(`
+INSERT IGNORE INTO notifications (user_id, revision_id, status)
+	SELECT notifications.user_id, r.id, "Queued"
+	FROM announcement_revisions r
+	JOIN notifications ON notifications.announcement_id = r.announcement_id AND notifications.status = "Sent"
+	WHERE r.id IN (?)
+`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query using IN clause: %w", err)
+	}
+	results, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue revision notifications: %w", err)
+	}
+	notificationRows, err := results.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get number of revision notifications inserted: %w", err)
+	}
+	log.Printf("Enqueued %d revision notifications.\n", notificationRows)
 
+	query, args, err = sqlx.In(`UPDATE announcement_revisions SET sent = TRUE WHERE id IN (?)`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to prepare query using IN clause: %w", err)
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to mark notices as sent: %w", err)
+	}
 	return nil
 }
 
-func SelectNotificationsToSend(ctx context.Context, db *sqlx.DB, now, deadline time.Time) ([]Notification, error) {
+// EnqueueEventReminders queues a reminder for each user attending an
+// event that starts within the event's reminder window. The window is
+// the event's reminder_minutes if set, or else the conference's. Users
+// who have turned off event reminders are skipped, and the unique key
+// on (user_id, event_id) ensures nobody is reminded twice.
+func EnqueueEventReminders(db *sqlx.DB) error {
+	query := `
+INSERT IGNORE INTO notifications (user_id, event_id, status)
+	SELECT users.id as user_id, events.id as event_id, "Queued" as status
+	FROM events
+	JOIN conferences ON conferences.id = events.conference_id
+	JOIN rsvp ON rsvp.event_id = events.id AND rsvp.attending
+	JOIN users ON users.id = rsvp.user_id
+	WHERE users.event_reminders AND ` + hasPushToken + `
+		AND events.deleted_at IS NULL AND conferences.deleted_at IS NULL
+		AND COALESCE(events.reminder_minutes, conferences.reminder_minutes) > 0
+		AND events.start_time > UTC_TIMESTAMP
+		AND events.start_time <= UTC_TIMESTAMP + INTERVAL COALESCE(events.reminder_minutes, conferences.reminder_minutes) MINUTE
+	ORDER BY events.start_time asc
+`
+	results, err := db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to insert event reminders: %w", err)
+	}
+	reminderRows, err := results.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get number of event reminders inserted: %w", err)
+	}
+	log.Printf("Enqueued %d event reminders.\n", reminderRows)
+	return nil
+}
+
+// SelectNotificationsToSend leases up to limit queued notifications
+// until deadline and returns them. Notifications for announcements
+// and events in the trash are held until they are restored.
+//
+// Each lease counts as an attempt to send a notification. Leased
+// notifications whose lease expired after maxAttempts attempts are
+// marked as failed rather than leased again, since they have crashed
+// or stalled the sender every time.
+//
+// Notifications of retracted announcements that were being sent when
+// the announcement was retracted are canceled once they are no longer
+// leased.
+func SelectNotificationsToSend(ctx context.Context, db *sqlx.DB, now, deadline time.Time, limit, maxAttempts int) ([]Notification, error) {
 	var notifications []Notification
 
+	cancelQuery := `
+DELETE notifications FROM notifications
+JOIN announcements ON announcements.id = notifications.announcement_id
+WHERE announcements.retracted
+  AND (notifications.status = "Queued" OR (notifications.status = "Leased" AND notifications.lease_expiration < ?))
+`
+	if _, err := db.ExecContext(ctx, cancelQuery, now.Unix()); err != nil {
+		return nil, fmt.Errorf("failed to cancel notifications of retracted announcements: %w", err)
+	}
+
+	failQuery := `
+UPDATE notifications
+SET status = "Failed", lease_expiration = 0, last_error = "lease expired before the notification was sent"
+WHERE status = "Leased" AND lease_expiration < ? AND attempts >= ?
+`
+	if _, err := db.ExecContext(ctx, failQuery, now.Unix(), maxAttempts); err != nil {
+		return nil, fmt.Errorf("failed to fail notifications with expired leases: %w", err)
+	}
+
 	err := transact(db, func(tx *sqlx.Tx) error {
 		selectQuery := `
 			SELECT
-				CONCAT(user_id,"-",announcement_id) as id,
+				notifications.id,
 				notifications.user_id,
-				notifications.announcement_id,
+				COALESCE(notifications.announcement_id, announcement_revisions.announcement_id) as announcement_id,
+				notifications.event_id,
+				notifications.revision_id,
+				notifications.attempts,
 				expo_push_token,
-				announcements.title,
-				announcements.message as body
+				push_token_type,
+				CASE announcement_revisions.action
+					WHEN "edit" THEN CONCAT("Updated: ", announcements.title)
+					WHEN "retract" THEN CONCAT("Retracted: ", announcements.title)
+					ELSE COALESCE(announcements.title, "Event Reminder")
+				END as title,
+				COALESCE(announcement_revisions.notice, announcements.message, CONCAT(
+					events.name, " is starting soon", COALESCE(CONCAT(" at ", locations.name), ""), "."
+				)) as body,
+				COALESCE(announcement_revisions.action, "") as revision_action,
+				COALESCE(notifications.event_id, announcements.link_event_id) as link_event_id,
+				announcements.link_info_id,
+				announcements.badge,
+				COALESCE(announcements.sound, "default") as sound,
+				COALESCE(announcements.channel_id, "") as channel_id,
+				COALESCE(announcements.priority, "high") as priority,
+				COALESCE(announcements.ttl_seconds, 0) as ttl_seconds,
+				COALESCE(announcements.urgent, 0) as urgent,
+				users.quiet_hours_start,
+				users.quiet_hours_end,
+				COALESCE(NULLIF(users.timezone, ""), conferences.timezone, "") as timezone
 			FROM notifications
 			JOIN users ON users.id = notifications.user_id
-			JOIN announcements ON announcements.id = notifications.announcement_id
+			LEFT JOIN announcement_revisions ON announcement_revisions.id = notifications.revision_id
+			LEFT JOIN announcements ON announcements.id = COALESCE(notifications.announcement_id, announcement_revisions.announcement_id)
+			LEFT JOIN events ON events.id = notifications.event_id
+			LEFT JOIN locations ON locations.id = events.location_id
+			LEFT JOIN conferences ON conferences.id = COALESCE(announcements.conference_id, events.conference_id)
 			WHERE
 				notifications.status in ("Queued", "Leased")
-				AND expo_push_token like "ExponentPushToken[%]"
-				AND lease_expiration < ?
-			LIMIT 100
+				AND NOT (notifications.announcement_id IS NOT NULL AND announcements.retracted)
+				AND announcements.deleted_at IS NULL
+				AND events.deleted_at IS NULL
+				AND ` + hasPushToken + `
+				AND notifications.lease_expiration < ?
+				AND notifications.next_attempt_time <= ?
+				AND notifications.attempts < ?
+			LIMIT ?
 			FOR UPDATE SKIP LOCKED
 		`
 
-		if err := tx.SelectContext(ctx, &notifications, selectQuery, now.Unix()); err != nil {
+		if err := tx.SelectContext(ctx, &notifications, selectQuery, now.Unix(), now.Unix(), maxAttempts, limit); err != nil {
 			return fmt.Errorf("select query failed: %w", err)
 		}
 
@@ -91,17 +331,22 @@ func SelectNotificationsToSend(ctx context.Context, db *sqlx.DB, now, deadline t
 			return nil
 		}
 
-		idsToUpdate := make([]string, len(notifications))
-		for i, n := range notifications {
-			idsToUpdate[i] = n.ID
+		idsToUpdate := make([]int, len(notifications))
+		for i := range notifications {
+			idsToUpdate[i] = notifications[i].ID
+			// Leasing a notification counts as an attempt to send it,
+			// so that a notification that repeatedly crashes the
+			// sender eventually fails.
+			notifications[i].Attempts++
 		}
 
 		updateQuery := `
 			UPDATE notifications
 				SET
 					status = "Leased",
+					attempts = attempts + 1,
     				lease_expiration = ` + strconv.FormatInt(deadline.Unix(), 10) + `
-			WHERE CONCAT(user_id,"-",announcement_id) IN (?)
+			WHERE id IN (?)
 		`
 
 		query, args, err := sqlx.In(updateQuery, idsToUpdate)
@@ -109,7 +354,7 @@ func SelectNotificationsToSend(ctx context.Context, db *sqlx.DB, now, deadline t
 			return fmt.Errorf("failed to prepare query using IN clause: %w", err)
 		}
 
-		if _, err := tx.QueryContext(ctx, query, args...); err != nil {
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
 			return fmt.Errorf("update query failed: %w", err)
 		}
 
@@ -127,9 +372,16 @@ func UpdateNotificationStatus(ctx context.Context, db *sqlx.DB, notifications []
 	// It seems that sqlx doesn't let you use NamedExec with a slice of structs
 	// when doing an UPDATE, so we will do an INSERT ... ON DUPLICATE KEY UPDATE instead.
 	query := `
-INSERT INTO notifications (user_id, announcement_id, status, receipt)
-VALUES (:user_id, :announcement_id, :status, :receipt)
-ON DUPLICATE KEY UPDATE status=VALUES(status), receipt=VALUES(receipt)
+INSERT INTO notifications (id, user_id, status, receipt, attempts, next_attempt_time, last_error)
+VALUES (:id, :user_id, :status, :receipt, :attempts, :next_attempt_time, :last_error)
+ON DUPLICATE KEY UPDATE
+	status=VALUES(status),
+	attempts=VALUES(attempts),
+	sent_time=IF(VALUES(status) = "Sent", UNIX_TIMESTAMP(), sent_time),
+	receipt=VALUES(receipt),
+	next_attempt_time=VALUES(next_attempt_time),
+	last_error=VALUES(last_error),
+	lease_expiration=0
 `
 	_, err := db.NamedExecContext(ctx, query, notifications)
 	if err != nil {
@@ -138,3 +390,241 @@ ON DUPLICATE KEY UPDATE status=VALUES(status), receipt=VALUES(receipt)
 
 	return nil
 }
+
+// RequeueFailedNotifications moves an announcement's failed
+// notifications back into the queue with a fresh set of attempts.
+// Retracted announcements can't be requeued.
+func RequeueFailedNotifications(db DB, announcementID string) error {
+	if announcementID == "" {
+		return errors.New("announcement id must be provided")
+	}
+	var retracted []bool
+	if err := db.Select(&retracted, `SELECT retracted FROM announcements WHERE id = ?`, announcementID); err != nil {
+		return fmt.Errorf("failed to look up announcement: %w", err)
+	}
+	if len(retracted) == 0 {
+		return errors.New("found no announcement with given id")
+	}
+	if retracted[0] {
+		return errors.New("cannot retry the notifications of a retracted announcement")
+	}
+	query := `
+UPDATE notifications
+SET status = "Queued", attempts = 0, next_attempt_time = 0, lease_expiration = 0, last_error = NULL
+WHERE announcement_id = ? AND status = "Failed"
+`
+	if _, err := db.Exec(query, announcementID); err != nil {
+		return fmt.Errorf("failed to requeue notifications: %w", err)
+	}
+	return nil
+}
+
+// SelectNotificationsAwaitingReceipt returns sent Expo notifications
+// whose push receipt hasn't been checked yet. Expo only keeps receipts for
+// about a day, so older notifications are ignored.
+func SelectNotificationsAwaitingReceipt(ctx context.Context, db *sqlx.DB, limit int) ([]Notification, error) {
+	query := `
+SELECT notifications.id, notifications.user_id, notifications.announcement_id, notifications.status, notifications.receipt
+FROM notifications
+JOIN users ON users.id = notifications.user_id
+WHERE
+	notifications.status = "Sent"
+	AND users.push_token_type = "expo"
+	AND notifications.receipt IS NOT NULL AND notifications.receipt != ""
+	AND notifications.receipt_status IS NULL
+	AND notifications.timestamp > NOW() - INTERVAL 1 DAY
+LIMIT ?
+`
+	var notifications []Notification
+	if err := db.SelectContext(ctx, &notifications, query, limit); err != nil {
+		return nil, fmt.Errorf("failed to select notifications awaiting receipt: %w", err)
+	}
+	return notifications, nil
+}
+
+func UpdateNotificationReceiptStatus(ctx context.Context, db *sqlx.DB, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	query := `
+INSERT INTO notifications (id, user_id, receipt_status)
+VALUES (:id, :user_id, :receipt_status)
+ON DUPLICATE KEY UPDATE receipt_status=VALUES(receipt_status)
+`
+	_, err := db.NamedExecContext(ctx, query, notifications)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// NotificationProgress counts an announcement's notifications by
+// status.
+type NotificationProgress struct {
+	AnnouncementID int `db:"announcement_id" json:"announcement_id"`
+	Queued         int `db:"queued" json:"queued"`
+	Leased         int `db:"leased" json:"leased"`
+	Sent           int `db:"sent" json:"sent"`
+	Failed         int `db:"failed" json:"failed"`
+}
+
+const notificationProgressQuery = `
+SELECT
+	announcement_id,
+	SUM(status = "Queued") as queued,
+	SUM(status = "Leased") as leased,
+	SUM(status = "Sent") as sent,
+	SUM(status = "Failed") as failed
+FROM notifications
+WHERE announcement_id IS NOT NULL
+GROUP BY announcement_id
+`
+
+// ListNotificationProgress returns the progress of sending each
+// announcement's notifications. If admin is set, only announcements
+// of conferences they have a role for are included.
+func ListNotificationProgress(db *sqlx.DB, admin string) ([]NotificationProgress, error) {
+	query := `
+SELECT progress.*
+FROM (` + notificationProgressQuery + `) progress
+JOIN announcements ON announcements.id = progress.announcement_id
+`
+	var args []interface{}
+	if admin != "" {
+		query += "WHERE " + adminConferenceCondition("announcements.conference_id") + "\n"
+		args = append(args, normalizeEmail(admin))
+	}
+	query += "ORDER BY progress.announcement_id"
+	var progress []NotificationProgress
+	if err := db.Select(&progress, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list notification progress: %w", err)
+	}
+	return progress, nil
+}
+
+// StatusCount is the number of notifications with a given status,
+// receipt status, or error.
+type StatusCount struct {
+	Status string `db:"status"`
+	Count  int    `db:"count"`
+}
+
+// DeliveryReport summarizes how an announcement's notifications were
+// delivered.
+type DeliveryReport struct {
+	Total int
+	// Statuses counts notifications by status (e.g., Sent or Failed).
+	Statuses []StatusCount
+	// Receipts counts sent Expo notifications by receipt status. Those
+	// whose receipt hasn't been checked yet are counted as "Pending".
+	Receipts []StatusCount
+	// Errors counts failed notifications by their last error.
+	Errors []StatusCount
+	// DeliveryTimes are how long each sent notification took to send
+	// after it was queued, in ascending order.
+	DeliveryTimes []time.Duration
+}
+
+// GetDeliveryReport returns a report on the delivery of an
+// announcement's notifications.
+func GetDeliveryReport(db *sqlx.DB, announcementID string) (DeliveryReport, error) {
+	var report DeliveryReport
+
+	const statusQuery = `
+SELECT status, count(*) as count
+FROM notifications
+WHERE announcement_id = ?
+GROUP BY status
+ORDER BY count desc
+`
+	if err := db.Select(&report.Statuses, statusQuery, announcementID); err != nil {
+		return report, fmt.Errorf("failed to count notifications by status: %w", err)
+	}
+	for _, c := range report.Statuses {
+		report.Total += c.Count
+	}
+
+	const receiptQuery = `
+SELECT COALESCE(notifications.receipt_status, "Pending") as status, count(*) as count
+FROM notifications
+JOIN users ON users.id = notifications.user_id
+WHERE notifications.announcement_id = ? AND notifications.status = "Sent" AND users.push_token_type = "expo"
+GROUP BY notifications.receipt_status
+ORDER BY count desc
+`
+	if err := db.Select(&report.Receipts, receiptQuery, announcementID); err != nil {
+		return report, fmt.Errorf("failed to count notifications by receipt status: %w", err)
+	}
+
+	const errorQuery = `
+SELECT COALESCE(last_error, "") as status, count(*) as count
+FROM notifications
+WHERE announcement_id = ? AND status = "Failed"
+GROUP BY last_error
+ORDER BY count desc
+`
+	if err := db.Select(&report.Errors, errorQuery, announcementID); err != nil {
+		return report, fmt.Errorf("failed to count notifications by error: %w", err)
+	}
+
+	const timeQuery = `
+SELECT sent_time - UNIX_TIMESTAMP(timestamp)
+FROM notifications
+WHERE announcement_id = ? AND status = "Sent" AND sent_time IS NOT NULL
+ORDER BY 1
+`
+	var seconds []int64
+	if err := db.Select(&seconds, timeQuery, announcementID); err != nil {
+		return report, fmt.Errorf("failed to select delivery times: %w", err)
+	}
+	for _, s := range seconds {
+		report.DeliveryTimes = append(report.DeliveryTimes, time.Duration(s)*time.Second)
+	}
+
+	return report, nil
+}
+
+// Percentile returns the p-th percentile (0 to 100) of the delivery
+// times using the nearest-rank method, or 0 if there are none.
+func (r DeliveryReport) Percentile(p float64) time.Duration {
+	n := len(r.DeliveryTimes)
+	if n == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(n)))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > n {
+		rank = n
+	}
+	return r.DeliveryTimes[rank-1]
+}
+
+type ReceiptErrorCount struct {
+	AnnouncementID int    `db:"announcement_id"`
+	Title          string `db:"title"`
+	ReceiptStatus  string `db:"receipt_status"`
+	Count          int    `db:"count"`
+}
+
+// ListReceiptErrors returns the number of push receipts that came back
+// with an error other than DeviceNotRegistered, grouped by announcement
+// and error. These usually indicate a problem with the message or our
+// credentials that an admin needs to look into.
+func ListReceiptErrors(db *sqlx.DB) ([]ReceiptErrorCount, error) {
+	query := `
+SELECT notifications.announcement_id, announcements.title, notifications.receipt_status, count(*) as count
+FROM notifications
+JOIN announcements ON announcements.id = notifications.announcement_id
+WHERE receipt_status IS NOT NULL AND receipt_status NOT IN ("Ok", "DeviceNotRegistered")
+GROUP BY notifications.announcement_id, announcements.title, notifications.receipt_status
+ORDER BY notifications.announcement_id desc
+`
+	var counts []ReceiptErrorCount
+	if err := db.Select(&counts, query); err != nil {
+		return nil, fmt.Errorf("failed to list receipt errors: %w", err)
+	}
+	return counts, nil
+}
@@ -1,10 +1,12 @@
 package model
 
+import "time"
+
 type RSVP struct {
-	EventID   int    `db:"event_id"`
-	UserID    int    `db:"user_id"`
-	Attending bool   `db:"attending"`
-	Timestamp string `db:"timestamp"`
+	EventID   int       `db:"event_id"`
+	UserID    int       `db:"user_id"`
+	Attending bool      `db:"attending"`
+	Timestamp time.Time `db:"timestamp"`
 }
 
 // TODO
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// trashTables maps the entity types that can be moved to the trash to
+// their tables.
+var trashTables = map[string]string{
+	AuditEntityConference:   "conferences",
+	AuditEntityLocation:     "locations",
+	AuditEntityEvent:        "events",
+	AuditEntityInfo:         "info",
+	AuditEntityAnnouncement: "announcements",
+}
+
+// Trashable reports whether entities of the type are moved to the
+// trash when they are deleted, rather than deleted outright.
+func Trashable(entityType string) bool {
+	_, ok := trashTables[entityType]
+	return ok
+}
+
+// TrashItem is a conference, location, event, info, or announcement
+// in the trash.
+type TrashItem struct {
+	EntityType string `db:"entity_type"`
+	ID         int    `db:"id"`
+	Name       string `db:"name"`
+	// ConferenceID is null for locations and info, which are shared by
+	// all conferences.
+	ConferenceID sql.NullInt64 `db:"conference_id"`
+	DeletedAt    time.Time     `db:"deleted_at"`
+}
+
+// ListTrash returns everything in the trash, most recently deleted
+// first. If admin is set, the conferences, events, and announcements
+// are limited to those of conferences that the admin with this email
+// has a role for.
+func ListTrash(db *sqlx.DB, admin string) ([]TrashItem, error) {
+	query := `
+SELECT entity_type, id, name, conference_id, deleted_at
+FROM (
+	SELECT 'conference' AS entity_type, id, name, id AS conference_id, deleted_at FROM conferences WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT 'location', id, name, NULL, deleted_at FROM locations WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT 'event', id, COALESCE(name, ''), conference_id, deleted_at FROM events WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT 'info', id, title, NULL, deleted_at FROM info WHERE deleted_at IS NOT NULL
+	UNION ALL
+	SELECT 'announcement', id, title, conference_id, deleted_at FROM announcements WHERE deleted_at IS NOT NULL
+) trash
+`
+	var args []interface{}
+	if admin != "" {
+		query += "WHERE trash.conference_id IS NULL OR " + adminConferenceCondition("trash.conference_id") + "\n"
+		args = append(args, normalizeEmail(admin))
+	}
+	query += "ORDER BY trash.deleted_at DESC, entity_type, id"
+	var items []TrashItem
+	if err := db.Select(&items, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list trash: %w", err)
+	}
+	if items == nil {
+		items = make([]TrashItem, 0)
+	}
+	return items, nil
+}
+
+// RestoreFromTrash takes the entity out of the trash.
+func RestoreFromTrash(db DB, entityType, id string) error {
+	table, ok := trashTables[entityType]
+	if !ok {
+		return fmt.Errorf("invalid entity type %q", entityType)
+	}
+	res, err := db.Exec("UPDATE "+table+" SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return fmt.Errorf("failed to restore %v: %w", entityType, err)
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("found no %v in the trash with given id", entityType)
+	}
+	return nil
+}
+
+// PurgeFromTrash deletes the entity in the trash for good. Events are
+// purged along with their RSVPs and reminders, and announcements along
+// with their notifications. Anything else still referring to the
+// entity, such as the events of a conference, has to be purged first.
+func PurgeFromTrash(db DB, entityType, id string) error {
+	table, ok := trashTables[entityType]
+	if !ok {
+		return fmt.Errorf("invalid entity type %q", entityType)
+	}
+	return transact(db, func(tx *sqlx.Tx) error {
+		var deleted int
+		if err := tx.Get(&deleted, "SELECT COUNT(*) FROM "+table+" WHERE id = ? AND deleted_at IS NOT NULL FOR UPDATE", id); err != nil {
+			return fmt.Errorf("failed to look up %v: %w", entityType, err)
+		}
+		if deleted == 0 {
+			return fmt.Errorf("found no %v in the trash with given id", entityType)
+		}
+
+		var dependents []string
+		switch entityType {
+		case AuditEntityEvent:
+			dependents = []string{
+				"DELETE FROM rsvp WHERE event_id = ?",
+				"DELETE FROM notifications WHERE event_id = ?",
+			}
+		case AuditEntityAnnouncement:
+			dependents = []string{
+				"DELETE FROM notifications WHERE announcement_id = ?",
+				"DELETE FROM notifications WHERE revision_id IN (SELECT id FROM announcement_revisions WHERE announcement_id = ?)",
+			}
+		}
+		for _, query := range dependents {
+			if _, err := tx.Exec(query, id); err != nil {
+				return fmt.Errorf("failed to purge %v: %w", entityType, err)
+			}
+		}
+
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE id = ?", id); err != nil {
+			if strings.Contains(err.Error(), "a foreign key constraint fails") {
+				return fmt.Errorf("cannot purge %v because other data still refers to it", entityType)
+			}
+			return fmt.Errorf("failed to purge %v: %w", entityType, err)
+		}
+		return nil
+	})
+}
@@ -2,20 +2,178 @@ package model
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type User struct {
-	ID            int    `db:"id"`
-	ConferenceID  int    `db:"conference_id"`
-	Name          string `db:"name"`
-	Email         string `db:"email"`
-	DeviceID      string `db:"device_id"`
-	DeviceName    string `db:"device_name"`
-	Platform      string `db:"platform"`
-	Timestamp     string `db:"timestamp"`
-	ExpoPushToken string `db:"expo_push_token"`
+	ID            int       `db:"id"`
+	ConferenceID  int       `db:"conference_id"`
+	Name          string    `db:"name"`
+	Email         string    `db:"email"`
+	DeviceID      string    `db:"device_id"`
+	DeviceName    string    `db:"device_name"`
+	Platform      string    `db:"platform"`
+	Timestamp     time.Time `db:"timestamp"`
+	ExpoPushToken string    `db:"expo_push_token"`
+	PushTokenType string    `db:"push_token_type"`
+	// DeviceSecret is what the user's device token is signed with. See
+	// AddUser.
+	DeviceSecret string `db:"device_secret"`
+}
+
+// NotificationPreferences are the settings a user has chosen for
+// which push notifications they receive and when.
+type NotificationPreferences struct {
+	EventReminders bool `json:"event_reminders"`
+	// MutedAnnouncementIcons lists the icons (which double as
+	// categories) of announcements the user doesn't want pushed.
+	MutedAnnouncementIcons []string `json:"muted_announcement_icons"`
+	// Non-urgent notifications aren't pushed between QuietHoursStart
+	// and QuietHoursEnd ("HH:MM") in the user's Timezone. Both are
+	// empty if the user hasn't set quiet hours.
+	QuietHoursStart string `json:"quiet_hours_start"`
+	QuietHoursEnd   string `json:"quiet_hours_end"`
+	Timezone        string `json:"timezone"`
+}
+
+// GetNotificationPreferences returns the user's notification
+// preferences.
+func GetNotificationPreferences(ctx context.Context, db *sqlx.DB, userID int) (NotificationPreferences, error) {
+	const query = `
+SELECT event_reminders, COALESCE(muted_announcement_icons, JSON_ARRAY()) AS muted_announcement_icons,
+       COALESCE(TIME_FORMAT(quiet_hours_start, '%H:%i'), '') AS quiet_hours_start,
+       COALESCE(TIME_FORMAT(quiet_hours_end, '%H:%i'), '') AS quiet_hours_end,
+       timezone
+FROM users
+WHERE id = ?
+`
+	var row struct {
+		EventReminders         bool   `db:"event_reminders"`
+		MutedAnnouncementIcons string `db:"muted_announcement_icons"`
+		QuietHoursStart        string `db:"quiet_hours_start"`
+		QuietHoursEnd          string `db:"quiet_hours_end"`
+		Timezone               string `db:"timezone"`
+	}
+	if err := db.GetContext(ctx, &row, query, userID); err != nil {
+		return NotificationPreferences{}, fmt.Errorf("failed to get notification preferences: %w", err)
+	}
+	prefs := NotificationPreferences{
+		EventReminders:  row.EventReminders,
+		QuietHoursStart: row.QuietHoursStart,
+		QuietHoursEnd:   row.QuietHoursEnd,
+		Timezone:        row.Timezone,
+	}
+	if err := json.Unmarshal([]byte(row.MutedAnnouncementIcons), &prefs.MutedAnnouncementIcons); err != nil {
+		return NotificationPreferences{}, fmt.Errorf("failed to decode muted announcement icons: %w", err)
+	}
+	return prefs, nil
+}
+
+// Push token types stored in users.push_token_type. Despite its name,
+// users.expo_push_token holds the device's push token for any of these.
+const (
+	PushTokenTypeExpo = "expo"
+	PushTokenTypeFCM  = "fcm"
+	PushTokenTypeAPNs = "apns"
+)
+
+// acceptsAnnouncement is an SQL condition that matches users who
+// haven't muted announcements with the icon of announcements.icon.
+const acceptsAnnouncement = `NOT COALESCE(JSON_CONTAINS(users.muted_announcement_icons, JSON_QUOTE(announcements.icon)), 0)`
+
+// hasPushToken is an SQL condition that matches users with a push
+// token we know how to deliver to.
+const hasPushToken = `(
+	(users.push_token_type = "expo" AND users.expo_push_token LIKE "ExponentPushToken[%]")
+	OR (users.push_token_type IN ("fcm", "apns") AND users.expo_push_token != "")
+)`
+
+// AddUser creates or updates the user for u.DeviceID, and returns its
+// ID and a new device secret to sign its device token with. The new
+// secret replaces the user's previous one, which revokes the tokens
+// signed with it.
+//
+// Someone who only knows a device's ID mustn't be able to take over its
+// user. So if the user already exists, AddUser calls authorize with its
+// ID and whether its device has used a device token, and only goes
+// ahead if authorize returns nil.
+func AddUser(db *sqlx.DB, u User, authorize func(userID int, tokenUsed bool) error) (int, string, error) {
+	secret, err := newDeviceSecret()
+	if err != nil {
+		return 0, "", err
+	}
+	u.DeviceSecret = secret
+	var id int
+	err = transact(db, func(tx *sqlx.Tx) error {
+		var existing struct {
+			ID        int  `db:"id"`
+			TokenUsed bool `db:"device_token_used"`
+		}
+		err := tx.Get(&existing, `SELECT id, device_token_used FROM users WHERE device_id = ? FOR UPDATE`, u.DeviceID)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return fmt.Errorf("failed to look up device: %w", err)
+		default:
+			if err := authorize(existing.ID, existing.TokenUsed); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.NamedExec(`
+INSERT INTO users (conference_id, name, email, device_id, device_name, platform, device_secret, timestamp)
+VALUES (:conference_id, :name, :email, :device_id, :device_name, :platform, :device_secret, NOW())
+ON DUPLICATE KEY UPDATE conference_id = VALUES(conference_id), name = VALUES(name), email = VALUES(email), device_secret = VALUES(device_secret)
+`, u); err != nil {
+			return fmt.Errorf("failed to save user: %w", err)
+		}
+		return tx.Get(&id, `SELECT id FROM users WHERE device_id = ?`, u.DeviceID)
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	return id, secret, nil
+}
+
+// newDeviceSecret returns a random device secret.
+func newDeviceSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate device secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetDeviceSecret returns the secret that the user's device token is
+// signed with. It returns sql.ErrNoRows if there is no such user.
+func GetDeviceSecret(ctx context.Context, db *sqlx.DB, userID int) (string, error) {
+	var secret string
+	err := db.GetContext(ctx, &secret, `SELECT device_secret FROM users WHERE id = ?`, userID)
+	return secret, err
+}
+
+// MarkDeviceTokenUsed records that a user's device has authenticated
+// with its device token, so its device ID alone is no longer enough to
+// act as the user.
+func MarkDeviceTokenUsed(ctx context.Context, db *sqlx.DB, userID int) error {
+	_, err := db.ExecContext(ctx, `UPDATE users SET device_token_used = TRUE WHERE id = ? AND NOT device_token_used`, userID)
+	return err
+}
+
+// GetLegacyDeviceUserID returns the ID of the user with deviceID, as
+// long as that device has never used a device token. It returns
+// sql.ErrNoRows otherwise.
+func GetLegacyDeviceUserID(ctx context.Context, db *sqlx.DB, deviceID string) (int, error) {
+	var id int
+	err := db.GetContext(ctx, &id, `SELECT id FROM users WHERE device_id = ? AND NOT device_token_used`, deviceID)
+	return id, err
 }
 
 type UserOptions struct {
@@ -2,60 +2,315 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/dxe/alc-mobile-api/model"
 	expo "github.com/jakehobbs/exponent-server-sdk-golang/sdk"
 	"github.com/jmoiron/sqlx"
+	"golang.org/x/time/rate"
 )
 
-func createExpoMessages(notifications []model.Notification) ([]model.Notification, []expo.PushMessage) {
-	var validNotifications []model.Notification
+// PushSender publishes push messages. It is satisfied by
+// *expo.PushClient, and lets tests substitute a fake for the Expo
+// service.
+type PushSender interface {
+	PublishMultipleWithContext(ctx context.Context, messages []expo.PushMessage) ([]expo.PushResponse, error)
+}
+
+// NativePushSender delivers a single notification directly through a
+// platform push service (FCM or APNs). Implementations return
+// ErrUnregistered if the push token is no longer valid, and a
+// *PermanentPushError if retrying the same message can't succeed.
+type NativePushSender interface {
+	Send(ctx context.Context, n model.Notification) error
+}
+
+// ErrUnregistered is returned by a NativePushSender when the device's
+// push token is no longer registered.
+var ErrUnregistered = errors.New("push token is not registered")
+
+// PermanentPushError is returned by a NativePushSender when the push
+// service rejected the message in a way that retrying won't fix.
+type PermanentPushError struct {
+	Reason string
+}
+
+func (e *PermanentPushError) Error() string {
+	return e.Reason
+}
+
+// Pushers holds the push providers used to deliver notifications.
+// A nil provider means that type of push token isn't configured, and
+// notifications for it are retried until they fail.
+type Pushers struct {
+	Expo PushSender
+	FCM  NativePushSender
+	APNs NativePushSender
+}
+
+// createExpoMessages returns the notifications with valid Expo push
+// tokens and their messages, and those with malformed tokens, which
+// are marked as failed since they can never be sent.
+func createExpoMessages(notifications []model.Notification) ([]model.Notification, []expo.PushMessage, []model.Notification) {
+	var validNotifications, invalidNotifications []model.Notification
 	var messages []expo.PushMessage
 	for _, n := range notifications {
 		pushToken, err := expo.NewExponentPushToken(n.ExpoPushToken)
 		if err != nil {
+			n.Status = StatusFailed
+			n.LastError = "invalid push token"
+			invalidNotifications = append(invalidNotifications, n)
 			continue
 		}
 		validNotifications = append(validNotifications, n)
-		messages = append(messages, expo.PushMessage{
-			To:    []expo.ExponentPushToken{pushToken},
-			Title: n.Title,
-			Body:  n.Body,
-		})
+		m := expo.PushMessage{
+			To:         []expo.ExponentPushToken{pushToken},
+			Title:      n.Title,
+			Body:       n.Body,
+			Data:       notificationData(n),
+			Sound:      n.Sound,
+			ChannelID:  n.ChannelID,
+			Priority:   n.Priority,
+			TTLSeconds: n.TTLSeconds,
+		}
+		if n.Badge.Valid {
+			m.Badge = int(n.Badge.Int64)
+		}
+		messages = append(messages, m)
+	}
+	return validNotifications, messages, invalidNotifications
+}
+
+const (
+	NotificationTypeAnnouncement          = "announcement"
+	NotificationTypeAnnouncementUpdated   = "announcement_updated"
+	NotificationTypeAnnouncementRetracted = "announcement_retracted"
+	NotificationTypeEventReminder         = "event_reminder"
+)
+
+// notificationData returns the data payload delivered alongside a
+// push notification. The app uses it to decide which screen to open
+// when the notification is tapped: an event or info page if one is
+// linked, otherwise the announcement itself.
+func notificationData(n model.Notification) map[string]string {
+	data := make(map[string]string)
+	if n.AnnouncementID.Valid {
+		switch n.RevisionAction {
+		case model.RevisionActionEdit:
+			data["type"] = NotificationTypeAnnouncementUpdated
+		case model.RevisionActionRetract:
+			data["type"] = NotificationTypeAnnouncementRetracted
+		default:
+			data["type"] = NotificationTypeAnnouncement
+		}
+		data["announcement_id"] = strconv.FormatInt(n.AnnouncementID.Int64, 10)
+	} else if n.EventID.Valid {
+		data["type"] = NotificationTypeEventReminder
+	}
+	if n.LinkEventID.Valid {
+		data["event_id"] = strconv.FormatInt(n.LinkEventID.Int64, 10)
 	}
-	return validNotifications, messages
+	if n.LinkInfoID.Valid {
+		data["info_id"] = strconv.FormatInt(n.LinkInfoID.Int64, 10)
+	}
+	return data
 }
 
 const (
+	StatusQueued              = "Queued"
 	StatusSent                = "Sent"
 	StatusDeviceNotRegistered = "DeviceNotRegistered"
-	StatusUnknownError        = "UnknownError"
+	StatusFailed              = "Failed"
+	// StatusSuppressed is for notifications that were dropped rather
+	// than delivered late. See deferForQuietHours.
+	StatusSuppressed = "Suppressed"
 )
 
-func SendNotifications(db *sqlx.DB, client *expo.PushClient) (err error) {
+const (
+	// maxNotificationAttempts is the number of times we try to send a
+	// notification before giving up and marking it as failed.
+	maxNotificationAttempts = 5
+
+	// Retries back off exponentially starting from minRetryDelay, up to
+	// at most maxRetryDelay.
+	minRetryDelay = 30 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+// retryDelay returns how long to wait before the next attempt to send
+// a notification that has already been attempted the given number of
+// times.
+func retryDelay(attempts int) time.Duration {
+	delay := minRetryDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
+
+// markForRetry updates n so that it is either requeued with a backoff
+// or, if it has run out of attempts, marked as failed.
+func markForRetry(n *model.Notification, now time.Time, reason string) {
+	n.LastError = reason
+	if n.Attempts >= maxNotificationAttempts {
+		n.Status = StatusFailed
+		return
+	}
+	n.Status = StatusQueued
+	n.NextAttemptTime = now.Add(retryDelay(n.Attempts)).Unix()
+}
+
+// releaseNotification puts a leased notification back in the queue
+// without counting the lease as an attempt, for when we never got to
+// try sending it.
+func releaseNotification(n *model.Notification) {
+	n.Attempts--
+	n.Status = StatusQueued
+}
+
+// parseClock parses a time of day as stored in a MySQL TIME column and
+// returns it as minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04:05", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// quietHoursEnd reports whether now falls within the quiet hours from
+// start to end, given as minutes since midnight in now's location. If
+// so, it also returns when the quiet hours end. Quiet hours may wrap
+// past midnight, e.g. from 22:00 to 07:00.
+func quietHoursEnd(start, end int, now time.Time) (time.Time, bool) {
+	if start == end {
+		return time.Time{}, false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	endOn := func(dayOffset int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+dayOffset, end/60, end%60, 0, 0, now.Location())
+	}
+	if start < end {
+		if minute >= start && minute < end {
+			return endOn(0), true
+		}
+		return time.Time{}, false
+	}
+	switch {
+	case minute >= start:
+		return endOn(1), true
+	case minute < end:
+		return endOn(0), true
+	}
+	return time.Time{}, false
+}
+
+// deferForQuietHours holds back a non-urgent notification if its user
+// is in their quiet hours, and reports whether it did. Announcements
+// are requeued for when the quiet hours end. Event reminders would be
+// stale by then, so they are suppressed instead.
+func deferForQuietHours(n *model.Notification, now time.Time) bool {
+	if n.Urgent || !n.QuietHoursStart.Valid || !n.QuietHoursEnd.Valid {
+		return false
+	}
+	start, err := parseClock(n.QuietHoursStart.String)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(n.QuietHoursEnd.String)
+	if err != nil {
+		return false
+	}
+	tz := n.Timezone
+	if tz == "" {
+		tz = model.DefaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return false
+	}
+	until, quiet := quietHoursEnd(start, end, now.In(loc))
+	if !quiet {
+		return false
+	}
+
+	// Leasing the notification counted as an attempt, but we didn't
+	// actually try to send it.
+	n.Attempts--
+	if n.EventID.Valid {
+		n.Status = StatusSuppressed
+		n.LastError = "quiet hours"
+		return true
+	}
+	n.Status = StatusQueued
+	n.NextAttemptTime = until.Unix()
+	return true
+}
+
+// SendNotifications sends a single batch of queued notifications.
+func SendNotifications(db *sqlx.DB, pushers Pushers) error {
+	_, err := sendNotificationBatch(context.Background(), db, pushers, nil)
+	return err
+}
+
+// sendNotificationBatch leases and sends up to expoBatchSize queued
+// notifications, and returns how many it leased. If limiter is
+// non-nil, it waits for the limiter to allow the whole batch before
+// sending any of it.
+//
+// If ctx is canceled while the batch is in flight, notifications that
+// weren't sent are released back to the queue. The results are still
+// written to the database, so that the process doesn't exit holding
+// any leases.
+func sendNotificationBatch(ctx context.Context, db *sqlx.DB, pushers Pushers, limiter *rate.Limiter) (int, error) {
 	currentTime := time.Now()
 	fiveMinFromNow := time.Now().Add(5 * time.Minute)
 
-	ctx, cancel := context.WithDeadline(context.Background(), fiveMinFromNow)
+	ctx, cancel := context.WithDeadline(ctx, fiveMinFromNow)
 	defer cancel()
 
-	notifications, err := model.SelectNotificationsToSend(ctx, db, currentTime, fiveMinFromNow)
+	notifications, err := model.SelectNotificationsToSend(ctx, db, currentTime, fiveMinFromNow, expoBatchSize, maxNotificationAttempts)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if len(notifications) == 0 {
-		return nil
+		return 0, nil
 	}
 
-	validNotifications, validExpoMessages := createExpoMessages(notifications)
+	if limiter != nil {
+		if err := limiter.WaitN(ctx, len(notifications)); err != nil {
+			// We couldn't get a turn before the lease ran out, so put
+			// the batch back without counting it as an attempt.
+			for i := range notifications {
+				releaseNotification(&notifications[i])
+			}
+			if err := model.UpdateNotificationStatus(context.Background(), db, notifications); err != nil {
+				return 0, fmt.Errorf("failed to release notifications: %w", err)
+			}
+			return 0, fmt.Errorf("rate limiter: %w", err)
+		}
+	}
 
-	expoResponses, err := client.PublishMultipleWithContext(ctx, validExpoMessages)
-	if err != nil {
-		return fmt.Errorf("failed to publish messages via expo api: %w", err)
+	// Route each notification to the provider for its token type,
+	// holding back those for users in their quiet hours.
+	var expoNotifications, nativeNotifications, deferredNotifications []model.Notification
+	for _, n := range notifications {
+		if deferForQuietHours(&n, currentTime) {
+			deferredNotifications = append(deferredNotifications, n)
+		} else if n.PushTokenType == model.PushTokenTypeExpo {
+			expoNotifications = append(expoNotifications, n)
+		} else {
+			nativeNotifications = append(nativeNotifications, n)
+		}
 	}
 
 	// Create a slice to store IDs of unregistered users to use to update
@@ -63,6 +318,75 @@ func SendNotifications(db *sqlx.DB, client *expo.PushClient) (err error) {
 	// an extra time.
 	var unregisteredUsers []int
 
+	var publishErr error
+	if len(expoNotifications) > 0 {
+		expoNotifications, publishErr = sendExpoNotifications(ctx, pushers.Expo, currentTime, expoNotifications)
+	}
+	for i := range nativeNotifications {
+		if ctx.Err() != nil {
+			releaseNotification(&nativeNotifications[i])
+			continue
+		}
+		sendNativeNotification(ctx, pushers, currentTime, &nativeNotifications[i])
+	}
+
+	processed := append(expoNotifications, nativeNotifications...)
+	processed = append(processed, deferredNotifications...)
+	for _, n := range processed {
+		if n.Status == StatusDeviceNotRegistered {
+			unregisteredUsers = append(unregisteredUsers, n.UserID)
+		}
+	}
+
+	// Write the new status to the database. This uses a fresh context
+	// since ctx may have been canceled while we were sending.
+	err = model.UpdateNotificationStatus(context.Background(), db, processed)
+	if err != nil {
+		return len(notifications), fmt.Errorf("failed to update notification status: %w", err)
+	}
+
+	// Remove tokens from users table for unregistered users.
+	err = model.RemovePushTokens(context.Background(), db, unregisteredUsers)
+	if err != nil {
+		return len(notifications), fmt.Errorf("failed to update remove unregistered push tokens from users: %w", err)
+	}
+
+	if publishErr != nil {
+		return len(notifications), fmt.Errorf("failed to publish messages via expo api: %w", publishErr)
+	}
+
+	return len(notifications), nil
+}
+
+// sendExpoNotifications publishes notifications with Expo push tokens
+// in a single batch and returns them with their status updated.
+func sendExpoNotifications(ctx context.Context, sender PushSender, now time.Time, notifications []model.Notification) ([]model.Notification, error) {
+	validNotifications, validExpoMessages, invalidNotifications := createExpoMessages(notifications)
+	if len(validNotifications) == 0 {
+		return invalidNotifications, nil
+	}
+
+	if sender == nil {
+		for i := range validNotifications {
+			markForRetry(&validNotifications[i], now, "expo push is not configured")
+		}
+		return append(validNotifications, invalidNotifications...), nil
+	}
+
+	expoResponses, err := sender.PublishMultipleWithContext(ctx, validExpoMessages)
+	if err != nil {
+		// Release the whole batch back to the queue rather than leaving
+		// it leased until the lease expires.
+		for i := range validNotifications {
+			if ctx.Err() != nil {
+				releaseNotification(&validNotifications[i])
+			} else {
+				markForRetry(&validNotifications[i], now, truncateError(err.Error()))
+			}
+		}
+		return append(validNotifications, invalidNotifications...), err
+	}
+
 	// Update each notification with the status.
 	for i, r := range expoResponses {
 		switch {
@@ -71,48 +395,177 @@ func SendNotifications(db *sqlx.DB, client *expo.PushClient) (err error) {
 			validNotifications[i].Receipt = r.ID
 		case r.Details["error"] == expo.ErrorDeviceNotRegistered:
 			validNotifications[i].Status = StatusDeviceNotRegistered
-			unregisteredUsers = append(unregisteredUsers, validNotifications[i].UserID)
+		case r.Details["error"] == expo.ErrorMessageTooBig:
+			// Retrying won't make the message any smaller.
+			validNotifications[i].Status = StatusFailed
+			validNotifications[i].LastError = expo.ErrorMessageTooBig
 		default:
-			validNotifications[i].Status = StatusUnknownError
+			reason := r.Details["error"]
+			if reason == "" {
+				reason = r.Message
+			}
+			markForRetry(&validNotifications[i], now, truncateError(reason))
 		}
 	}
 
-	// Write the new status to the database.
-	err = model.UpdateNotificationStatus(ctx, db, validNotifications)
-	if err != nil {
-		return fmt.Errorf("failed to update notification status: %w", err)
+	return append(validNotifications, invalidNotifications...), nil
+}
+
+// sendNativeNotification delivers a notification with an FCM or APNs
+// push token and updates its status.
+func sendNativeNotification(ctx context.Context, pushers Pushers, now time.Time, n *model.Notification) {
+	var sender NativePushSender
+	switch n.PushTokenType {
+	case model.PushTokenTypeFCM:
+		sender = pushers.FCM
+	case model.PushTokenTypeAPNs:
+		sender = pushers.APNs
+	}
+	if sender == nil {
+		markForRetry(n, now, n.PushTokenType+" push is not configured")
+		return
 	}
 
-	// Remove tokens from users table for unregistered users.
-	err = model.RemovePushTokens(ctx, db, unregisteredUsers)
-	if err != nil {
-		return fmt.Errorf("failed to update remove unregistered push tokens from users: %w", err)
+	err := sender.Send(ctx, *n)
+	var permanent *PermanentPushError
+	switch {
+	case err == nil:
+		n.Status = StatusSent
+	case errors.Is(err, ErrUnregistered):
+		n.Status = StatusDeviceNotRegistered
+	case errors.As(err, &permanent):
+		n.Status = StatusFailed
+		n.LastError = truncateError(err.Error())
+	case ctx.Err() != nil:
+		releaseNotification(n)
+	default:
+		markForRetry(n, now, truncateError(err.Error()))
 	}
+}
 
+// truncateError shortens an error message to fit in
+// notifications.last_error.
+func truncateError(msg string) string {
+	const maxLen = 200
+	if len(msg) > maxLen {
+		return msg[:maxLen]
+	}
+	return msg
+}
+
+const (
+	// Expo accepts at most 100 messages per request, so that is how
+	// many notifications each worker leases at a time.
+	expoBatchSize = 100
+
+	// sendPollInterval is how long an idle worker waits before checking
+	// the queue again.
+	sendPollInterval = 15 * time.Second
+)
+
+// SenderConfig configures the pool of notification senders.
+type SenderConfig struct {
+	// Workers is the number of batches sent concurrently.
+	Workers int
+	// RateLimit is the most messages per second that may be sent.
+	// Only the leader sends, so this limits all replicas together.
+	RateLimit int
+}
+
+func (c SenderConfig) validate() error {
+	if c.Workers <= 0 {
+		return fmt.Errorf("number of workers must be positive, not %d", c.Workers)
+	}
+	if c.RateLimit <= 0 {
+		return fmt.Errorf("rate limit must be positive, not %d", c.RateLimit)
+	}
 	return nil
+}
 
+// SendNotificationsWrapper runs a pool of workers that send queued
+// notifications while this process is the leader, so that the rate
+// limit holds across replicas. Workers keep going while there are full
+// batches to send, and otherwise wait for sendPollInterval. Because
+// notifications are leased with SKIP LOCKED, workers never send the
+// same notification twice, even while leadership changes hands.
+//
+// It returns once ctx is canceled and every worker has finished its
+// current batch.
+func SendNotificationsWrapper(ctx context.Context, db *sqlx.DB, pushers Pushers, config SenderConfig, leader *Leader) {
+	burst := config.RateLimit
+	if burst < expoBatchSize {
+		burst = expoBatchSize
+	}
+	limiter := rate.NewLimiter(rate.Limit(config.RateLimit), burst)
+
+	log.Printf("Starting %d notification workers limited to %d messages per second.\n", config.Workers, config.RateLimit)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			sendNotificationsWorker(ctx, db, pushers, limiter, leader, id)
+		}(i)
+	}
+	wg.Wait()
 }
 
-func SendNotificationsWrapper(db *sqlx.DB, client *expo.PushClient) {
-	for {
-		log.Println("Notifications worker started.")
-		if err := SendNotifications(db, client); err != nil {
-			log.Printf("Notifications worker failed: %v\n", err.Error())
-		} else {
-			log.Println("Notifications worker finished.")
+func sendNotificationsWorker(ctx context.Context, db *sqlx.DB, pushers Pushers, limiter *rate.Limiter, leader *Leader, id int) {
+	for ctx.Err() == nil {
+		if !leader.IsLeader() {
+			sleepContext(ctx, sendPollInterval)
+			continue
+		}
+		n, err := sendNotificationBatch(ctx, db, pushers, limiter)
+		if err != nil {
+			log.Printf("Notifications worker %d failed: %v\n", id, err.Error())
+		} else if n > 0 {
+			log.Printf("Notifications worker %d processed %d notifications.\n", id, n)
+		}
+		if err != nil || n < expoBatchSize {
+			sleepContext(ctx, sendPollInterval)
 		}
-		time.Sleep(15 * time.Second)
 	}
 }
 
-func EnqueueAnnouncementNotificationsWrapper(db *sqlx.DB) {
-	for {
+// sleepContext pauses for d, or until ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// EnqueueAnnouncementNotificationsWrapper enqueues notifications for
+// due announcements every minute while this process is the leader.
+func EnqueueAnnouncementNotificationsWrapper(ctx context.Context, db *sqlx.DB, leader *Leader) {
+	for ; ctx.Err() == nil; sleepContext(ctx, 60*time.Second) {
+		if !leader.IsLeader() {
+			continue
+		}
 		log.Println("Starting to enqueue announcement notifications.")
 		if err := model.EnqueueAnnouncementNotifications(db); err != nil {
 			log.Printf("Failed to enqueue announcement notifications: %v\n", err.Error())
 		} else {
 			log.Println("Finished enqueuing announcement notifications.")
 		}
-		time.Sleep(60 * time.Second)
+	}
+}
+
+// EnqueueEventRemindersWrapper enqueues event reminders every minute
+// while this process is the leader.
+func EnqueueEventRemindersWrapper(ctx context.Context, db *sqlx.DB, leader *Leader) {
+	for ; ctx.Err() == nil; sleepContext(ctx, 60*time.Second) {
+		if !leader.IsLeader() {
+			continue
+		}
+		log.Println("Starting to enqueue event reminders.")
+		if err := model.EnqueueEventReminders(db); err != nil {
+			log.Printf("Failed to enqueue event reminders: %v\n", err.Error())
+		} else {
+			log.Println("Finished enqueuing event reminders.")
+		}
 	}
 }
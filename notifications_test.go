@@ -0,0 +1,852 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dxe/alc-mobile-api/model"
+	expo "github.com/jakehobbs/exponent-server-sdk-golang/sdk"
+	"github.com/jmoiron/sqlx"
+	mysqltest "github.com/lestrrat-go/test-mysqld"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Second, retryDelay(1))
+	assert.Equal(t, time.Minute, retryDelay(2))
+	assert.Equal(t, 2*time.Minute, retryDelay(3))
+	assert.Equal(t, time.Hour, retryDelay(20))
+}
+
+func TestMarkForRetry(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	n := model.Notification{Attempts: 1}
+	markForRetry(&n, now, "MessageRateExceeded")
+	assert.Equal(t, StatusQueued, n.Status)
+	assert.Equal(t, int64(1030), n.NextAttemptTime)
+	assert.Equal(t, "MessageRateExceeded", n.LastError)
+
+	n = model.Notification{Attempts: maxNotificationAttempts}
+	markForRetry(&n, now, "MessageRateExceeded")
+	assert.Equal(t, StatusFailed, n.Status)
+}
+
+func TestSenderConfigValidate(t *testing.T) {
+	assert.NoError(t, SenderConfig{Workers: 4, RateLimit: 100}.validate())
+	assert.Error(t, SenderConfig{Workers: 0, RateLimit: 100}.validate())
+	assert.Error(t, SenderConfig{Workers: 4, RateLimit: 0}.validate())
+	assert.Error(t, SenderConfig{Workers: 4, RateLimit: -1}.validate())
+}
+
+func TestCreateExpoMessages(t *testing.T) {
+	notifications, messages, invalid := createExpoMessages([]model.Notification{
+		{
+			ExpoPushToken:  "ExponentPushToken[announcement]",
+			AnnouncementID: sql.NullInt64{Int64: 7, Valid: true},
+			LinkInfoID:     sql.NullInt64{Int64: 3, Valid: true},
+			Title:          "Title",
+			Body:           "Body",
+			Badge:          sql.NullInt64{Int64: 1, Valid: true},
+			Sound:          "default",
+			ChannelID:      "alerts",
+			Priority:       "high",
+			TTLSeconds:     600,
+		},
+		{
+			ExpoPushToken: "ExponentPushToken[reminder]",
+			EventID:       sql.NullInt64{Int64: 9, Valid: true},
+			LinkEventID:   sql.NullInt64{Int64: 9, Valid: true},
+		},
+		{ExpoPushToken: "not-an-expo-token"},
+	})
+	assert.Len(t, notifications, 2)
+	if assert.Len(t, invalid, 1) {
+		assert.Equal(t, StatusFailed, invalid[0].Status)
+		assert.Equal(t, "invalid push token", invalid[0].LastError)
+	}
+	if !assert.Len(t, messages, 2) {
+		return
+	}
+
+	m := messages[0]
+	assert.Equal(t, map[string]string{"type": "announcement", "announcement_id": "7", "info_id": "3"}, m.Data)
+	assert.Equal(t, 1, m.Badge)
+	assert.Equal(t, "default", m.Sound)
+	assert.Equal(t, "alerts", m.ChannelID)
+	assert.Equal(t, "high", m.Priority)
+	assert.Equal(t, 600, m.TTLSeconds)
+
+	assert.Equal(t, map[string]string{"type": "event_reminder", "event_id": "9"}, messages[1].Data)
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, 9, day, hour, minute, 0, 0, loc)
+	}
+	const ten, sevenPM, tenPM, sevenAM = 10 * 60, 19 * 60, 22 * 60, 7 * 60
+
+	// Within a single day.
+	end, quiet := quietHoursEnd(ten, sevenPM, at(24, 12, 0))
+	assert.True(t, quiet)
+	assert.Equal(t, at(24, 19, 0), end)
+	_, quiet = quietHoursEnd(ten, sevenPM, at(24, 19, 0))
+	assert.False(t, quiet)
+
+	// Wrapping past midnight.
+	end, quiet = quietHoursEnd(tenPM, sevenAM, at(24, 23, 30))
+	assert.True(t, quiet)
+	assert.Equal(t, at(25, 7, 0), end)
+	end, quiet = quietHoursEnd(tenPM, sevenAM, at(25, 6, 59))
+	assert.True(t, quiet)
+	assert.Equal(t, at(25, 7, 0), end)
+	_, quiet = quietHoursEnd(tenPM, sevenAM, at(25, 12, 0))
+	assert.False(t, quiet)
+
+	_, quiet = quietHoursEnd(tenPM, tenPM, at(25, 22, 0))
+	assert.False(t, quiet)
+}
+
+func TestDeferForQuietHours(t *testing.T) {
+	// 23:00 in New York.
+	now := time.Date(2021, 9, 25, 3, 0, 0, 0, time.UTC)
+	quiet := func(n model.Notification) model.Notification {
+		n.Attempts = 1
+		n.QuietHoursStart = sql.NullString{String: "22:00:00", Valid: true}
+		n.QuietHoursEnd = sql.NullString{String: "07:00:00", Valid: true}
+		n.Timezone = "America/New_York"
+		return n
+	}
+
+	n := quiet(model.Notification{AnnouncementID: sql.NullInt64{Int64: 1, Valid: true}})
+	assert.True(t, deferForQuietHours(&n, now))
+	assert.Equal(t, StatusQueued, n.Status)
+	assert.Equal(t, time.Date(2021, 9, 25, 11, 0, 0, 0, time.UTC).Unix(), n.NextAttemptTime)
+	assert.Equal(t, 0, n.Attempts)
+
+	n = quiet(model.Notification{EventID: sql.NullInt64{Int64: 1, Valid: true}})
+	assert.True(t, deferForQuietHours(&n, now))
+	assert.Equal(t, StatusSuppressed, n.Status)
+
+	n = quiet(model.Notification{AnnouncementID: sql.NullInt64{Int64: 1, Valid: true}, Urgent: true})
+	assert.False(t, deferForQuietHours(&n, now))
+
+	n = model.Notification{AnnouncementID: sql.NullInt64{Int64: 1, Valid: true}}
+	assert.False(t, deferForQuietHours(&n, now), "users without quiet hours are never deferred")
+}
+
+// newTestDB starts a throwaway MySQL server and returns a connection
+// to a freshly initialized database.
+func newTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	db := newEmptyTestDB(t)
+	model.InitDatabase(db)
+	return db
+}
+
+// newEmptyTestDB is like newTestDB, but doesn't create any tables.
+func newEmptyTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	mysqld, err := mysqltest.NewMysqld(nil)
+	if err != nil {
+		t.Fatalf("failed to start mysqld: %s", err)
+	}
+	t.Cleanup(mysqld.Stop)
+	db, err := sqlx.Open("mysql", mysqld.Datasource("test", "", "", 0, mysqltest.WithParseTime(true)))
+	if err != nil {
+		t.Fatalf("failed to open MySQL connection: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// insertAnnouncementFixture creates a conference with one user per
+// push token and a due, approved announcement, and returns the user IDs in the
+// same order as tokens.
+func insertAnnouncementFixture(t *testing.T, db *sqlx.DB, tokens ...string) []int {
+	t.Helper()
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date) VALUES (1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00')`)
+	var userIDs []int
+	for i, token := range tokens {
+		res := db.MustExec(`
+INSERT INTO users (conference_id, device_id, timestamp, expo_push_token)
+VALUES (1, ?, NOW(), NULLIF(?, ''))
+`, "device-"+strconv.Itoa(i), token)
+		id, err := res.LastInsertId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		userIDs = append(userIDs, int(id))
+	}
+	db.MustExec(`
+INSERT INTO announcements (id, conference_id, title, message, long_message, icon, url, url_text, created_by, state, send_time, sent)
+VALUES (1, 1, 'Evacuate', 'Please leave the building.', 'Please leave the building.', 'exclamation-triangle', '', '', 'test@example.com', 'approved', UTC_TIMESTAMP - INTERVAL 1 MINUTE, 0)
+`)
+	return userIDs
+}
+
+type notificationRow struct {
+	Status          string         `db:"status"`
+	Attempts        int            `db:"attempts"`
+	NextAttemptTime int64          `db:"next_attempt_time"`
+	Receipt         sql.NullString `db:"receipt"`
+	ReceiptStatus   sql.NullString `db:"receipt_status"`
+}
+
+func getNotification(t *testing.T, db *sqlx.DB, userID int) notificationRow {
+	t.Helper()
+	var n notificationRow
+	err := db.Get(&n, `
+SELECT status, attempts, next_attempt_time, receipt, receipt_status
+FROM notifications
+WHERE user_id = ? AND announcement_id = 1
+`, userID)
+	if err != nil {
+		t.Fatalf("failed to get notification for user %d: %v", userID, err)
+	}
+	return n
+}
+
+func getPushToken(t *testing.T, db *sqlx.DB, userID int) sql.NullString {
+	t.Helper()
+	var token sql.NullString
+	if err := db.Get(&token, `SELECT expo_push_token FROM users WHERE id = ?`, userID); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAnnouncementNotificationsEndToEnd(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db,
+		"ExponentPushToken[ok]",
+		"ExponentPushToken[unregistered]",
+		"ExponentPushToken[toobig]",
+		"",
+	)
+
+	fake := newFakeExpo()
+	fake.ticketErrors["ExponentPushToken[unregistered]"] = expo.ErrorDeviceNotRegistered
+	fake.receiptErrors["ExponentPushToken[toobig]"] = expo.ErrorMessageTooBig
+
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := fake.sent()
+	assert.Len(t, sent, 3)
+	for _, m := range sent {
+		assert.Equal(t, "Evacuate", m.Title)
+		assert.Equal(t, "Please leave the building.", m.Body)
+		assert.Equal(t, "1", m.Data["announcement_id"])
+		assert.Equal(t, "default", m.Sound)
+	}
+
+	assert.Equal(t, StatusSent, getNotification(t, db, users[0]).Status)
+	assert.Equal(t, StatusDeviceNotRegistered, getNotification(t, db, users[1]).Status)
+	assert.False(t, getPushToken(t, db, users[1]).Valid)
+	assert.Equal(t, StatusSent, getNotification(t, db, users[2]).Status)
+
+	var queued int
+	db.Get(&queued, `SELECT count(*) FROM notifications WHERE user_id = ?`, users[3])
+	assert.Equal(t, 0, queued, "users without a push token should not be notified")
+
+	var sentFlag bool
+	db.Get(&sentFlag, `SELECT sent FROM announcements WHERE id = 1`)
+	assert.True(t, sentFlag)
+
+	if err := CheckNotificationReceipts(db, fake); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ReceiptStatusOK, getNotification(t, db, users[0]).ReceiptStatus.String)
+	assert.Equal(t, ReceiptStatusMessageTooBig, getNotification(t, db, users[2]).ReceiptStatus.String)
+
+	// Nothing is left to send.
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, fake.sent(), 3)
+
+	report, err := model.GetDeliveryReport(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, report.Total)
+	assert.ElementsMatch(t, []model.StatusCount{{Status: StatusSent, Count: 2}, {Status: StatusDeviceNotRegistered, Count: 1}}, report.Statuses)
+	assert.ElementsMatch(t, []model.StatusCount{{Status: ReceiptStatusOK, Count: 1}, {Status: ReceiptStatusMessageTooBig, Count: 1}}, report.Receipts)
+	assert.Len(t, report.DeliveryTimes, 2)
+}
+
+func TestDeliveryReportPercentile(t *testing.T) {
+	var report model.DeliveryReport
+	assert.Equal(t, time.Duration(0), report.Percentile(50))
+
+	for i := 1; i <= 10; i++ {
+		report.DeliveryTimes = append(report.DeliveryTimes, time.Duration(i)*time.Second)
+	}
+	assert.Equal(t, 5*time.Second, report.Percentile(50))
+	assert.Equal(t, 9*time.Second, report.Percentile(90))
+	assert.Equal(t, 10*time.Second, report.Percentile(99))
+	assert.Equal(t, 10*time.Second, report.Percentile(100))
+	assert.Equal(t, time.Second, report.Percentile(0))
+}
+
+func TestAnnouncementApproval(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[ok]")
+
+	// Saving the announcement sends it back for approval.
+	announcement, err := model.GetAnnouncementByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	announcement.CreatedBy = "editor@example.com"
+	announcement.UpdatedBy = "editor@example.com"
+	if _, err := model.SaveAnnouncement(db, announcement); err != nil {
+		t.Fatal(err)
+	}
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	var queued int
+	db.Get(&queued, `SELECT count(*) FROM notifications WHERE user_id = ?`, users[0])
+	assert.Equal(t, 0, queued, "drafts should not be sent")
+
+	assert.Error(t, model.ApproveAnnouncement(db, "1", "editor@example.com"))
+	assert.Error(t, model.ApproveAnnouncement(db, "1", "test@example.com"), "the author can't approve somebody else's edit")
+	if err := model.ApproveAnnouncement(db, "1", "reviewer@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, model.ApproveAnnouncement(db, "1", "reviewer@example.com"), "approving twice should fail")
+
+	announcement, err = model.GetAnnouncementByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, model.AnnouncementStateApproved, announcement.State)
+	assert.Equal(t, "test@example.com", announcement.CreatedBy, "editing doesn't change the author")
+	assert.Equal(t, "editor@example.com", announcement.UpdatedBy)
+	assert.Equal(t, "reviewer@example.com", announcement.ApprovedBy.String)
+
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusQueued, getNotification(t, db, users[0]).Status)
+}
+
+func TestAnnouncementAudience(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db,
+		"ExponentPushToken[ios]",
+		"ExponentPushToken[android]",
+		"ExponentPushToken[muted]",
+		"",
+	)
+	db.MustExec(`UPDATE announcements SET sent = TRUE`)
+	db.MustExec(`UPDATE users SET platform = 'ios' WHERE id IN (?, ?)`, users[0], users[2])
+	db.MustExec(`UPDATE users SET platform = 'android' WHERE id = ?`, users[1])
+	db.MustExec(`UPDATE users SET muted_announcement_icons = JSON_ARRAY('bullhorn') WHERE id = ?`, users[2])
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Hall', '', '')`)
+	db.MustExec(`INSERT INTO events (id, conference_id, name, start_time, length, location_id) VALUES (1, 1, 'Lunch', '2021-09-24 12:00:00', 60, 1)`)
+	db.MustExec(`INSERT INTO rsvp (event_id, user_id, attending, timestamp) VALUES (1, ?, 1, NOW())`, users[0])
+
+	// The recipients counted before an announcement is saved are the
+	// ones it is sent to.
+	for _, tt := range []struct {
+		name   string
+		target func(a *model.Announcement)
+		want   int
+	}{
+		{"everyone", func(a *model.Announcement) {}, 2},
+		{"platform", func(a *model.Announcement) { a.TargetPlatform = "ios" }, 1},
+		{"event", func(a *model.Announcement) { a.TargetEventID = sql.NullInt64{Int64: 1, Valid: true} }, 1},
+		{"users", func(a *model.Announcement) { a.TargetUserIDs = []int{users[1], users[2], users[3]} }, 1},
+		{"registered", func(a *model.Announcement) {
+			a.TargetRegisteredAfter = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+		}, 0},
+		{"no tokens", func(a *model.Announcement) { a.TargetUserIDs = []int{users[3]} }, 0},
+	} {
+		announcement := model.Announcement{
+			ConferenceID: 1,
+			Title:        tt.name,
+			Icon:         "bullhorn",
+			CreatedBy:    "author@example.com",
+			SendTime:     time.Now().Add(-time.Minute),
+			Sound:        "default",
+			Priority:     "default",
+		}
+		tt.target(&announcement)
+		count, err := model.CountAnnouncementRecipients(db, announcement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.want, count, tt.name)
+
+		id, err := model.SaveAnnouncement(db, announcement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := model.ApproveAnnouncement(db, strconv.Itoa(id), "reviewer@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+			t.Fatal(err)
+		}
+		var queued int
+		if err := db.Get(&queued, `SELECT COUNT(*) FROM notifications WHERE announcement_id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, count, queued, tt.name)
+
+		// Announcements are marked as sent even if nobody could be
+		// notified, so they aren't evaluated again.
+		var sent bool
+		if err := db.Get(&sent, `SELECT sent FROM announcements WHERE id = ?`, id); err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, sent, tt.name)
+	}
+}
+
+func TestSendNotificationsRetriesTransientErrors(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db,
+		"ExponentPushToken[ratelimited]",
+		"ExponentPushToken[ok]",
+	)
+
+	fake := newFakeExpo()
+	fake.ticketErrors["ExponentPushToken[ratelimited]"] = expo.ErrorMessageRateExceeded
+
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := getNotification(t, db, users[0])
+	assert.Equal(t, StatusQueued, n.Status)
+	assert.Equal(t, 1, n.Attempts)
+	assert.Greater(t, n.NextAttemptTime, time.Now().Unix())
+	assert.Equal(t, StatusSent, getNotification(t, db, users[1]).Status)
+
+	// Once the backoff has elapsed and attempts run out, the
+	// notification ends up failed.
+	for i := 1; i < maxNotificationAttempts; i++ {
+		db.MustExec(`UPDATE notifications SET next_attempt_time = 0`)
+		if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n = getNotification(t, db, users[0])
+	assert.Equal(t, StatusFailed, n.Status)
+	assert.Equal(t, maxNotificationAttempts, n.Attempts)
+
+	if err := model.RequeueFailedNotifications(db, "1"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusQueued, getNotification(t, db, users[0]).Status)
+}
+
+func TestSendNotificationsInvalidToken(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "not-an-expo-token", "ExponentPushToken[ok]")
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeExpo()
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Malformed tokens fail straight away rather than when their
+	// lease expires.
+	assert.Len(t, fake.sent(), 1)
+	n := getNotification(t, db, users[0])
+	assert.Equal(t, StatusFailed, n.Status)
+	assert.Equal(t, 1, n.Attempts)
+	var lastError string
+	if err := db.Get(&lastError, `SELECT last_error FROM notifications WHERE user_id = ?`, users[0]); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "invalid push token", lastError)
+	assert.Equal(t, StatusSent, getNotification(t, db, users[1]).Status)
+}
+
+func TestSendNotificationsExpiredLease(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[crash]")
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each time the notification is leased, the sender crashes before
+	// recording the result, so the lease expires.
+	for i := 0; i < maxNotificationAttempts; i++ {
+		now := time.Now()
+		leased, err := model.SelectNotificationsToSend(context.Background(), db, now, now.Add(time.Minute), expoBatchSize, maxNotificationAttempts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, leased, 1)
+		db.MustExec(`UPDATE notifications SET lease_expiration = 0`)
+	}
+
+	// Once its attempts have run out, it fails instead of being leased
+	// again.
+	fake := newFakeExpo()
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, fake.sent())
+	n := getNotification(t, db, users[0])
+	assert.Equal(t, StatusFailed, n.Status)
+	assert.Equal(t, maxNotificationAttempts, n.Attempts)
+}
+
+func TestRetractLeasedNotifications(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[leased]", "ExponentPushToken[failed]")
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	leased, err := model.SelectNotificationsToSend(context.Background(), db, now, now.Add(time.Minute), expoBatchSize, maxNotificationAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, leased, 2)
+	db.MustExec(`UPDATE notifications SET status = "Failed", lease_expiration = 0 WHERE user_id = ?`, users[1])
+
+	// The sender crashes while the announcement is retracted, so its
+	// lease expires.
+	if err := model.RetractAnnouncement(db, 1, "editor@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	later := now.Add(2 * time.Minute)
+	leased, err = model.SelectNotificationsToSend(context.Background(), db, later, later.Add(time.Minute), expoBatchSize, maxNotificationAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, leased)
+	var statuses []string
+	if err := db.Select(&statuses, `SELECT status FROM notifications`); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{StatusFailed}, statuses)
+
+	// Failed notifications of retracted announcements stay failed.
+	assert.Error(t, model.RequeueFailedNotifications(db, "1"))
+	assert.Equal(t, StatusFailed, getNotification(t, db, users[1]).Status)
+}
+
+func TestQuietHoursConferenceTimezone(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[local]", "ExponentPushToken[traveling]")
+	db.MustExec(`UPDATE conferences SET timezone = 'Asia/Tokyo' WHERE id = 1`)
+	db.MustExec(`UPDATE users SET quiet_hours_start = '22:00', quiet_hours_end = '07:00'`)
+	db.MustExec(`UPDATE users SET timezone = 'America/New_York' WHERE id = ?`, users[1])
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Quiet hours are kept in the conference's time zone unless the
+	// user has told us theirs.
+	now := time.Now()
+	leased, err := model.SelectNotificationsToSend(context.Background(), db, now, now.Add(time.Minute), expoBatchSize, maxNotificationAttempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timezones := make(map[int]string)
+	for _, n := range leased {
+		timezones[n.UserID] = n.Timezone
+	}
+	assert.Equal(t, map[int]string{users[0]: "Asia/Tokyo", users[1]: "America/New_York"}, timezones)
+}
+
+func TestSendNotificationBatches(t *testing.T) {
+	db := newTestDB(t)
+	tokens := make([]string, expoBatchSize+50)
+	for i := range tokens {
+		tokens[i] = "ExponentPushToken[" + strconv.Itoa(i) + "]"
+	}
+	insertAnnouncementFixture(t, db, tokens...)
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeExpo()
+	limiter := rate.NewLimiter(rate.Inf, expoBatchSize)
+	for _, want := range []int{expoBatchSize, 50, 0} {
+		n, err := sendNotificationBatch(context.Background(), db, Pushers{Expo: fake}, limiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, n)
+	}
+	assert.Len(t, fake.sent(), len(tokens))
+
+	progress, err := model.ListNotificationProgress(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []model.NotificationProgress{{AnnouncementID: 1, Sent: len(tokens)}}, progress)
+}
+
+func TestSendNotificationsPublishError(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[ok]")
+
+	fake := newFakeExpo()
+	fake.publishErr = errors.New("expo is down")
+
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, SendNotifications(db, Pushers{Expo: fake}))
+
+	n := getNotification(t, db, users[0])
+	assert.Equal(t, StatusQueued, n.Status, "batch should be released rather than left leased")
+	assert.Equal(t, 1, n.Attempts)
+	assert.Empty(t, fake.sent())
+}
+
+// shutdownSender simulates the process being asked to stop while a
+// batch is being published.
+type shutdownSender struct {
+	cancel context.CancelFunc
+}
+
+func (s shutdownSender) PublishMultipleWithContext(ctx context.Context, messages []expo.PushMessage) ([]expo.PushResponse, error) {
+	s.cancel()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSendNotificationsShutdown(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db, "ExponentPushToken[ok]")
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := sendNotificationBatch(ctx, db, Pushers{Expo: shutdownSender{cancel}}, nil)
+	assert.Error(t, err)
+
+	n := getNotification(t, db, users[0])
+	assert.Equal(t, StatusQueued, n.Status, "lease should be released on shutdown")
+	assert.Equal(t, 0, n.Attempts, "an interrupted send shouldn't count as an attempt")
+}
+
+func TestEventRemindersEndToEnd(t *testing.T) {
+	db := newTestDB(t)
+	db.MustExec(`INSERT INTO conferences (id, name, start_date, end_date, reminder_minutes) VALUES (1, 'Test Conference', '2021-09-24 00:00:00', '2021-09-30 00:00:00', 15)`)
+	db.MustExec(`INSERT INTO locations (id, name, address, city) VALUES (1, 'Main Hall', '1 Main St', 'Oakland')`)
+	db.MustExec(`
+INSERT INTO events (id, conference_id, name, start_time, length, location_id, reminder_minutes)
+VALUES
+	(1, 1, 'Workshop', UTC_TIMESTAMP + INTERVAL 10 MINUTE, 60, 1, NULL),
+	(2, 1, 'Keynote', UTC_TIMESTAMP + INTERVAL 10 MINUTE, 60, 1, 5),
+	(3, 1, 'Lunch', UTC_TIMESTAMP + INTERVAL 2 HOUR, 60, 1, NULL)
+`)
+	db.MustExec(`
+INSERT INTO users (id, conference_id, device_id, timestamp, expo_push_token, event_reminders)
+VALUES
+	(1, 1, 'attending', NOW(), 'ExponentPushToken[attending]', 1),
+	(2, 1, 'opted-out', NOW(), 'ExponentPushToken[opted-out]', 0),
+	(3, 1, 'not-attending', NOW(), 'ExponentPushToken[not-attending]', 1)
+`)
+	db.MustExec(`
+INSERT INTO rsvp (event_id, user_id, attending, timestamp)
+VALUES (1, 1, 1, NOW()), (2, 1, 1, NOW()), (3, 1, 1, NOW()), (1, 2, 1, NOW()), (1, 3, 0, NOW())
+`)
+
+	// Running the scheduler twice must not remind anyone twice.
+	for i := 0; i < 2; i++ {
+		if err := model.EnqueueEventReminders(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fake := newFakeExpo()
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the Workshop is within its reminder window: the Keynote
+	// overrides it to 5 minutes, and Lunch is hours away.
+	sent := fake.sent()
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, []expo.ExponentPushToken{"ExponentPushToken[attending]"}, sent[0].To)
+		assert.Equal(t, "Event Reminder", sent[0].Title)
+		assert.Equal(t, "Workshop is starting soon at Main Hall.", sent[0].Body)
+		assert.Equal(t, "1", sent[0].Data["event_id"])
+		assert.Equal(t, "high", sent[0].Priority)
+	}
+
+	var status string
+	if err := db.Get(&status, `SELECT status FROM notifications WHERE user_id = 1 AND event_id = 1`); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StatusSent, status)
+}
+
+func TestAnnouncementRevisionsEndToEnd(t *testing.T) {
+	db := newTestDB(t)
+	users := insertAnnouncementFixture(t, db,
+		"ExponentPushToken[first]",
+		"ExponentPushToken[second]",
+	)
+
+	fake := newFakeExpo()
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, fake.sent(), 2)
+
+	announcement, err := model.GetAnnouncementByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Notices are only sent once another admin approves them.
+	approveAndSend := func(sentBefore int) {
+		t.Helper()
+		drafts, err := model.ListDraftRevisions(db, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, drafts, 1)
+		assert.Len(t, fake.sent(), sentBefore, "drafts should not be sent")
+		for _, r := range drafts {
+			assert.Error(t, model.ApproveAnnouncementRevision(db, strconv.Itoa(r.ID), "editor@example.com"))
+			if err := model.ApproveAnnouncementRevision(db, strconv.Itoa(r.ID), "reviewer@example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+			t.Fatal(err)
+		}
+		if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	announcement.Title = "Evacuate Now"
+	if err := model.ReviseAnnouncement(db, announcement, "editor@example.com", "The exits have changed."); err != nil {
+		t.Fatal(err)
+	}
+	approveAndSend(2)
+	if err := model.RetractAnnouncement(db, 1, "editor@example.com", "False alarm."); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, model.RetractAnnouncement(db, 1, "editor@example.com", ""), "retracting twice should fail")
+	approveAndSend(4)
+
+	sent := fake.sent()
+	if assert.Len(t, sent, 6) {
+		var titles, bodies, types []string
+		for _, m := range sent[2:] {
+			titles = append(titles, m.Title)
+			bodies = append(bodies, m.Body)
+			types = append(types, m.Data["type"])
+			assert.Equal(t, "1", m.Data["announcement_id"])
+		}
+		assert.ElementsMatch(t, []string{"Updated: Evacuate Now", "Updated: Evacuate Now", "Retracted: Evacuate Now", "Retracted: Evacuate Now"}, titles)
+		assert.ElementsMatch(t, []string{"The exits have changed.", "The exits have changed.", "False alarm.", "False alarm."}, bodies)
+		assert.ElementsMatch(t, []string{"announcement_updated", "announcement_updated", "announcement_retracted", "announcement_retracted"}, types)
+	}
+
+	revisions, err := model.ListAnnouncementRevisions(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, model.RevisionActionRetract, revisions[0].Action)
+		assert.Equal(t, "Evacuate Now", revisions[0].Title)
+		assert.Equal(t, model.RevisionActionEdit, revisions[1].Action)
+		assert.Equal(t, "Evacuate", revisions[1].Title)
+	}
+
+	// The original notifications are untouched.
+	assert.Equal(t, StatusSent, getNotification(t, db, users[0]).Status)
+}
+
+func TestReviseAnnouncementListing(t *testing.T) {
+	db := newTestDB(t)
+	insertAnnouncementFixture(t, db, "ExponentPushToken[first]")
+	fake := newFakeExpo()
+	if err := model.EnqueueAnnouncementNotifications(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := SendNotifications(db, Pushers{Expo: fake}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pinning a sent announcement isn't an edit.
+	announcement, err := model.GetAnnouncementByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	announcement.Pinned = true
+	announcement.Title = " Evacuate "
+	if err := model.ReviseAnnouncement(db, announcement, "editor@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := model.ListAnnouncementRevisions(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, revisions)
+	published, err := model.ListPublishedAnnouncements(context.Background(), db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, published, 1) {
+		assert.True(t, published[0].Pinned)
+		assert.False(t, published[0].Edited)
+	}
+
+	// Nor is unpinning or expiring it once it's retracted.
+	if err := model.RetractAnnouncement(db, 1, "editor@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	announcement.Pinned = false
+	announcement.ExpiresAt = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+	if err := model.ReviseAnnouncement(db, announcement, "editor@example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	announcement, err = model.GetAnnouncementByID(db, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, announcement.Pinned)
+	assert.True(t, announcement.ExpiresAt.Valid)
+
+	// Changing its content is still refused.
+	announcement.Title = "Evacuate Now"
+	assert.Error(t, model.ReviseAnnouncement(db, announcement, "editor@example.com", ""))
+}
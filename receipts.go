@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dxe/alc-mobile-api/model"
+	expo "github.com/jakehobbs/exponent-server-sdk-golang/sdk"
+	"github.com/jmoiron/sqlx"
+)
+
+// Expo allows up to 1000 receipt IDs per getReceipts request.
+const maxReceiptsPerRequest = 1000
+
+const (
+	ReceiptStatusOK                  = "Ok"
+	ReceiptStatusDeviceNotRegistered = "DeviceNotRegistered"
+	ReceiptStatusMessageTooBig       = "MessageTooBig"
+	ReceiptStatusMessageRateExceeded = "MessageRateExceeded"
+	ReceiptStatusInvalidCredentials  = "InvalidCredentials"
+	ReceiptStatusUnknownError        = "UnknownError"
+)
+
+// ExpoReceipt is a single push receipt returned by the Expo
+// getReceipts endpoint.
+type ExpoReceipt struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details"`
+}
+
+// ReceiptFetcher fetches push receipts for previously sent messages.
+// It is satisfied by *ReceiptClient, and lets tests substitute a fake
+// for the Expo service.
+type ReceiptFetcher interface {
+	GetReceipts(ctx context.Context, ids []string) (map[string]ExpoReceipt, error)
+}
+
+// ReceiptClient fetches push receipts from Expo. The Expo SDK we use
+// only supports sending, so this talks to the HTTP API directly.
+type ReceiptClient struct {
+	host        string
+	accessToken string
+	httpClient  *http.Client
+}
+
+func NewReceiptClient(accessToken string) *ReceiptClient {
+	return &ReceiptClient{
+		host:        expo.DefaultHost,
+		accessToken: accessToken,
+		httpClient:  expo.DefaultHTTPClient,
+	}
+}
+
+// GetReceipts returns the receipts for the given ticket IDs. Receipts
+// that Expo doesn't have yet are omitted from the result.
+func (c *ReceiptClient) GetReceipts(ctx context.Context, ids []string) (map[string]ExpoReceipt, error) {
+	body, err := json.Marshal(map[string][]string{"ids": ids})
+	if err != nil {
+		return nil, err
+	}
+
+	url := c.host + expo.DefaultBaseAPIURL + "/push/getReceipts"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	if c.accessToken != "" {
+		req.Header.Add("Authorization", "Bearer "+c.accessToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("invalid response (%d %s)", resp.StatusCode, resp.Status)
+	}
+
+	var r struct {
+		Data   map[string]ExpoReceipt `json:"data"`
+		Errors []map[string]string    `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.Errors != nil {
+		return nil, fmt.Errorf("expo returned errors: %v", r.Errors)
+	}
+	return r.Data, nil
+}
+
+// receiptStatus maps an Expo receipt onto the value stored in
+// notifications.receipt_status.
+func receiptStatus(r ExpoReceipt) string {
+	if r.Status == expo.SuccessStatus {
+		return ReceiptStatusOK
+	}
+	switch r.Details["error"] {
+	case ReceiptStatusDeviceNotRegistered, ReceiptStatusMessageTooBig,
+		ReceiptStatusMessageRateExceeded, ReceiptStatusInvalidCredentials:
+		return r.Details["error"]
+	}
+	return ReceiptStatusUnknownError
+}
+
+func CheckNotificationReceipts(db *sqlx.DB, fetcher ReceiptFetcher) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	notifications, err := model.SelectNotificationsAwaitingReceipt(ctx, db, maxReceiptsPerRequest)
+	if err != nil {
+		return err
+	}
+
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.Receipt
+	}
+
+	receipts, err := fetcher.GetReceipts(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("failed to get receipts via expo api: %w", err)
+	}
+
+	var checkedNotifications []model.Notification
+	var unregisteredUsers []int
+
+	for _, n := range notifications {
+		r, ok := receipts[n.Receipt]
+		if !ok {
+			// Expo doesn't have this receipt yet, so check again later.
+			continue
+		}
+		n.ReceiptStatus = receiptStatus(r)
+		switch n.ReceiptStatus {
+		case ReceiptStatusDeviceNotRegistered:
+			unregisteredUsers = append(unregisteredUsers, n.UserID)
+		case ReceiptStatusMessageTooBig, ReceiptStatusInvalidCredentials:
+			log.Printf("Push receipt %v for notification %d has error %v: %v\n", n.Receipt, n.ID, n.ReceiptStatus, r.Message)
+		}
+		checkedNotifications = append(checkedNotifications, n)
+	}
+
+	err = model.UpdateNotificationReceiptStatus(ctx, db, checkedNotifications)
+	if err != nil {
+		return fmt.Errorf("failed to update notification receipt status: %w", err)
+	}
+
+	err = model.RemovePushTokens(ctx, db, unregisteredUsers)
+	if err != nil {
+		return fmt.Errorf("failed to update remove unregistered push tokens from users: %w", err)
+	}
+
+	return nil
+}
+
+// CheckNotificationReceiptsWrapper checks push receipts every minute
+// while this process is the leader.
+func CheckNotificationReceiptsWrapper(ctx context.Context, db *sqlx.DB, fetcher ReceiptFetcher, leader *Leader) {
+	for ; ctx.Err() == nil; sleepContext(ctx, 60*time.Second) {
+		if !leader.IsLeader() {
+			continue
+		}
+		log.Println("Receipts worker started.")
+		if err := CheckNotificationReceipts(db, fetcher); err != nil {
+			log.Printf("Receipts worker failed: %v\n", err.Error())
+		} else {
+			log.Println("Receipts worker finished.")
+		}
+	}
+}